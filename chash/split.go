@@ -0,0 +1,116 @@
+package chash
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SplitBuckets is the number of percentage buckets used by Splitter,
+// giving a granularity of 0.01%.
+const SplitBuckets = 10000
+
+var (
+	ErrInvalidPercentage = fmt.Errorf("percentage must be between 0 and 100")
+)
+
+// Splitter routes a sticky percentage of keys to a canary pool and the rest to a stable pool.
+// Its implementation is thread-safe.
+//
+// A key is first hashed into one of SplitBuckets stable buckets. Keys whose bucket falls below
+// the canary percentage are routed to the canary pool, the rest to the stable pool. The chosen
+// pool's Maglev lookup table then selects the backend.
+//
+// Since the bucket of a key never changes, raising the percentage only moves keys from the stable
+// pool to the canary pool, and lowering it only moves keys back.
+type Splitter interface {
+	// Hash returns the backend for the given key.
+	// If the chosen pool has no backends, the key falls back to the other pool.
+	Hash(key uint64) string
+	// IsCanary returns true if the given key is routed to the canary pool.
+	IsCanary(key uint64) bool
+	// SetPercentage sets the percentage of keys routed to the canary pool, in [0, 100].
+	SetPercentage(percentage float64) error
+	// Percentage returns the percentage of keys routed to the canary pool.
+	Percentage() float64
+	// Stable returns the stable pool.
+	Stable() ConsistentHash
+	// Canary returns the canary pool.
+	Canary() ConsistentHash
+}
+
+type splitterImpl struct {
+	stable ConsistentHash
+	canary ConsistentHash
+
+	// threshold is the number of buckets routed to the canary pool.
+	threshold uint64
+	mtx       sync.RWMutex
+}
+
+// NewSplitter creates a new Splitter over the given pools,
+// routing the given percentage of keys to the canary pool.
+func NewSplitter(stable ConsistentHash, canary ConsistentHash, percentage float64) (Splitter, error) {
+	s := &splitterImpl{
+		stable: stable,
+		canary: canary,
+	}
+	if err := s.SetPercentage(percentage); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *splitterImpl) Hash(key uint64) string {
+	primary, secondary := s.stable, s.canary
+	if s.IsCanary(key) {
+		primary, secondary = s.canary, s.stable
+	}
+	if backend := primary.Hash(key); backend != "" {
+		return backend
+	}
+	return secondary.Hash(key)
+}
+
+func (s *splitterImpl) IsCanary(key uint64) bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return bucket(key) < s.threshold
+}
+
+func (s *splitterImpl) SetPercentage(percentage float64) error {
+	// also rejects NaN
+	if !(percentage >= 0 && percentage <= 100) {
+		return ErrInvalidPercentage
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.threshold = uint64(percentage*SplitBuckets/100 + 0.5)
+	return nil
+}
+
+func (s *splitterImpl) Percentage() float64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return float64(s.threshold) * 100 / SplitBuckets
+}
+
+func (s *splitterImpl) Stable() ConsistentHash {
+	return s.stable
+}
+
+func (s *splitterImpl) Canary() ConsistentHash {
+	return s.canary
+}
+
+// bucket returns the percentage bucket of the key.
+// The key is mixed first (splitmix64 finalizer), so that the bucket is independent
+// of the lookup table position, which is also derived from the key.
+func bucket(key uint64) uint64 {
+	key ^= key >> 30
+	key *= 0xbf58476d1ce4e5b9
+	key ^= key >> 27
+	key *= 0x94d049bb133111eb
+	key ^= key >> 31
+	return key % SplitBuckets
+}
@@ -0,0 +1,85 @@
+package chash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestSplitter(t *testing.T) {
+	newSplitter := func(t *testing.T, percentage float64) Splitter {
+		stable := NewConsistentHash(uint32(SmallSize))
+		stable.Add("stable1", "stable2", "stable3")
+		canary := NewConsistentHash(uint32(SmallSize))
+		canary.Add("canary1")
+		s, err := NewSplitter(stable, canary, percentage)
+		assert.NoError(t, err)
+		return s
+	}
+
+	t.Run("Percentage is respected", func(t *testing.T) {
+		tests := []float64{0, 1, 10, 25, 50, 100}
+		for _, percentage := range tests {
+			s := newSplitter(t, percentage)
+			const keys = 200000
+			canaries := 0
+			for key := uint64(0); key < keys; key++ {
+				if s.IsCanary(key) {
+					canaries++
+					assert.Equal(t, "canary1", s.Hash(key))
+				}
+			}
+			assert.InDelta(t, percentage, float64(canaries)*100/keys, 0.5, "percentage %v", percentage)
+		}
+	})
+
+	t.Run("Raising percentage only moves stable to canary", func(t *testing.T) {
+		s := newSplitter(t, 5)
+		const keys = 50000
+		before := make([]string, keys)
+		for key := range before {
+			before[key] = s.Hash(uint64(key))
+		}
+
+		assert.NoError(t, s.SetPercentage(20))
+		moved := 0
+		for key := range before {
+			after := s.Hash(uint64(key))
+			if after == before[key] {
+				continue
+			}
+			moved++
+			assert.Equal(t, "canary1", after, "key %d moved to %s", key, after)
+			assert.NotEqual(t, "canary1", before[key], "key %d left canary", key)
+		}
+		assert.InDelta(t, 15, float64(moved)*100/keys, 1)
+	})
+
+	t.Run("Stable keys keep their backend", func(t *testing.T) {
+		s := newSplitter(t, 30)
+		for key := uint64(0); key < 1000; key++ {
+			if !s.IsCanary(key) {
+				assert.Equal(t, s.Stable().Hash(key), s.Hash(key))
+			}
+		}
+	})
+
+	t.Run("Empty pool falls back", func(t *testing.T) {
+		stable := NewConsistentHash(uint32(SmallSize))
+		stable.Add("stable1")
+		s, err := NewSplitter(stable, NewConsistentHash(uint32(SmallSize)), 100)
+		assert.NoError(t, err)
+		assert.Equal(t, "stable1", s.Hash(42))
+	})
+
+	t.Run("Invalid percentage", func(t *testing.T) {
+		s := newSplitter(t, 10)
+		for _, percentage := range []float64{-1, 100.5, math.NaN()} {
+			assert.ErrorIs(t, s.SetPercentage(percentage), ErrInvalidPercentage)
+		}
+		assert.Equal(t, 10.0, s.Percentage())
+
+		_, err := NewSplitter(s.Stable(), s.Canary(), 101)
+		assert.ErrorIs(t, err, ErrInvalidPercentage)
+	})
+}
@@ -0,0 +1,409 @@
+// Command hash_analyzer replays a pcap or pcapng capture through tuple_hash and chash
+// and reports how packets, flows and bytes are distributed over the backends,
+// and how many flows would move to another backend under a membership change,
+// along with the distribution after the change.
+//
+// Usage:
+//
+//	hash_analyzer -pcap capture.pcapng -backends b1,b2,b3 -add b4 -remove b1
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"maglev-go/chash"
+	"maglev-go/tuple_hash"
+)
+
+const (
+	linuxSLLHeaderLen = 16
+	nullHeaderLen     = 4
+)
+
+var (
+	ErrNoBackends          = fmt.Errorf("no backends")
+	ErrInvalidSize         = fmt.Errorf("size must be a prime number")
+	ErrUnsupportedLinkType = fmt.Errorf("unsupported link type")
+)
+
+// pcapngMagic is the block type of the pcapng section header block, in either byte order.
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("hash_analyzer", flag.ContinueOnError)
+	pcapPath := fs.String("pcap", "", "pcap or pcapng file to read")
+	backends := fs.String("backends", "", "comma-separated list of backends")
+	size := fs.Uint("size", uint(chash.SmallSize), "size of the lookup table, must be a prime number")
+	mode := fs.String("mode", tuple_hash.Mode5Tuple.String(), "hash mode of non-fragmented packets")
+	fragmentMode := fs.String("fragment-mode", tuple_hash.Mode3Tuple.String(), "hash mode of fragmented packets")
+	followICMPErrors := fs.Bool("follow-icmp-errors", false, "hash ICMP errors by the flow they quote")
+	decapDepth := fs.Int("decap-depth", 0, "number of tunnel headers to strip before hashing")
+	add := fs.String("add", "", "comma-separated list of backends added by the membership change")
+	remove := fs.String("remove", "", "comma-separated list of backends removed by the membership change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *pcapPath == "" {
+		return fmt.Errorf("-pcap is required")
+	}
+
+	policy := tuple_hash.Policy{
+		FollowICMPErrors: *followICMPErrors,
+		Decap:            tuple_hash.Decap{MaxDepth: *decapDepth},
+	}
+	var err error
+	if policy.Mode, err = tuple_hash.ParseHashMode(*mode); err != nil {
+		return err
+	}
+	if policy.FragmentMode, err = tuple_hash.ParseHashMode(*fragmentMode); err != nil {
+		return err
+	}
+
+	if *size > math.MaxUint32 {
+		return fmt.Errorf("-size: %w, got %d", ErrInvalidSize, *size)
+	}
+
+	a, err := newAnalyzer(policy, uint32(*size), splitList(*backends), splitList(*add), splitList(*remove))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(*pcapPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := a.read(f); err != nil {
+		return fmt.Errorf("failed to read %s: %w", *pcapPath, err)
+	}
+	return a.report(stdout)
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+type stats struct {
+	packets uint64
+	flows   uint64
+	bytes   uint64
+}
+
+type flowStats struct {
+	packets uint64
+	bytes   uint64
+	// before and after are the backends of the flow before and after the membership change.
+	before string
+	after  string
+}
+
+// analyzer hashes packets to backends before and after a membership change.
+type analyzer struct {
+	policy tuple_hash.Policy
+	before chash.ConsistentHash
+	after  chash.ConsistentHash
+	// changed is true if the membership change is not empty.
+	changed bool
+
+	// backendsBefore and backendsAfter are the backends before and after the membership change,
+	// listed in the report even if no flow is hashed to them.
+	backendsBefore []string
+	backendsAfter  []string
+	added          []string
+	removed        []string
+
+	flows   map[flowKey]*flowStats
+	packets uint64
+	skipped uint64
+}
+
+func newAnalyzer(policy tuple_hash.Policy, size uint32, backends []string, add []string, remove []string) (*analyzer, error) {
+	if len(backends) == 0 {
+		return nil, ErrNoBackends
+	}
+	if !big.NewInt(int64(size)).ProbablyPrime(0) {
+		return nil, fmt.Errorf("%w, got %d", ErrInvalidSize, size)
+	}
+	a := &analyzer{
+		policy:         policy,
+		before:         chash.NewConsistentHash(size),
+		after:          chash.NewConsistentHash(size),
+		changed:        len(add) > 0 || len(remove) > 0,
+		backendsBefore: backends,
+		added:          add,
+		removed:        remove,
+		flows:          make(map[flowKey]*flowStats),
+	}
+	for _, backend := range append(append([]string{}, backends...), add...) {
+		if !slices.Contains(remove, backend) && !slices.Contains(a.backendsAfter, backend) {
+			a.backendsAfter = append(a.backendsAfter, backend)
+		}
+	}
+	a.before.Add(backends...)
+	a.after.Add(backends...)
+	a.after.Add(add...)
+	a.after.Remove(remove...)
+	return a, nil
+}
+
+// packetSource reads packets and their link type from a capture.
+type packetSource interface {
+	gopacket.PacketDataSource
+	linkType(ci gopacket.CaptureInfo) layers.LinkType
+}
+
+type pcapSource struct {
+	*pcapgo.Reader
+}
+
+func (s pcapSource) linkType(gopacket.CaptureInfo) layers.LinkType {
+	return s.LinkType()
+}
+
+type pcapngSource struct {
+	*pcapgo.NgReader
+}
+
+// linkType returns the link type of the interface the packet was captured on,
+// as a pcapng file may contain captures from several interfaces.
+func (s pcapngSource) linkType(ci gopacket.CaptureInfo) layers.LinkType {
+	if iface, err := s.Interface(ci.InterfaceIndex); err == nil {
+		return iface.LinkType
+	}
+	return s.LinkType()
+}
+
+// newPacketSource detects the format of the capture and returns a source reading it.
+func newPacketSource(r io.Reader) (packetSource, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(magic, pcapngMagic) {
+		ng, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, err
+		}
+		return pcapngSource{ng}, nil
+	}
+	reader, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	return pcapSource{reader}, nil
+}
+
+// read hashes all packets of the capture.
+// Packets that cannot be parsed are counted as skipped.
+func (a *analyzer) read(r io.Reader) error {
+	src, err := newPacketSource(r)
+	if err != nil {
+		return err
+	}
+	for {
+		data, ci, err := src.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		a.packets++
+
+		h, err := parsePacket(src.linkType(ci), data)
+		if err != nil {
+			a.skipped++
+			continue
+		}
+		a.add(&h, uint64(ci.Length))
+	}
+}
+
+// parsePacket parses a packet captured with the given link type.
+func parsePacket(linkType layers.LinkType, data []byte) (tuple_hash.Headers, error) {
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		return tuple_hash.ParseEthernet(data)
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		return tuple_hash.ParseIP(data)
+	case layers.LinkTypeLinuxSLL:
+		if len(data) < linuxSLLHeaderLen {
+			return tuple_hash.Headers{}, tuple_hash.ErrTruncated
+		}
+		switch binary.BigEndian.Uint16(data[14:]) {
+		case tuple_hash.EtherTypeIPv4, tuple_hash.EtherTypeIPv6:
+			return tuple_hash.ParseIP(data[linuxSLLHeaderLen:])
+		default:
+			return tuple_hash.Headers{}, tuple_hash.ErrUnsupported
+		}
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		// The address family is in host byte order, ParseIP checks the IP version instead
+		if len(data) < nullHeaderLen {
+			return tuple_hash.Headers{}, tuple_hash.ErrTruncated
+		}
+		return tuple_hash.ParseIP(data[nullHeaderLen:])
+	default:
+		return tuple_hash.Headers{}, fmt.Errorf("%w: %s", ErrUnsupportedLinkType, linkType)
+	}
+}
+
+// flowKey is the hash mode of a packet and the fields it hashes, which determine its backend.
+type flowKey struct {
+	mode      tuple_hash.HashMode
+	tuple     tuple_hash.FiveTuple
+	flowLabel uint32
+}
+
+// newFlowKey returns the key of the packet, whose flow is h according to tuple_hash.Policy.Flow.
+// Fields that are not hashed in the mode of the packet are zero, so that, e.g., the first fragment of
+// a packet and the following ones without ports are a single flow under the fragment-safe modes.
+func newFlowKey(policy tuple_hash.Policy, flow *tuple_hash.Headers) flowKey {
+	key := flowKey{mode: policy.ModeFor(flow), tuple: flow.FiveTuple}
+	switch key.mode {
+	case tuple_hash.Mode3Tuple:
+		key.tuple.SrcPort, key.tuple.DstPort = 0, 0
+	case tuple_hash.Mode2Tuple:
+		key.tuple.SrcPort, key.tuple.DstPort, key.tuple.Proto = 0, 0, 0
+	case tuple_hash.ModeFlowLabel:
+		key.tuple.SrcPort, key.tuple.DstPort, key.tuple.Proto = 0, 0, 0
+		key.flowLabel = flow.FlowLabel
+	}
+	return key
+}
+
+// add accounts a packet of the given length on the wire.
+// Packets are grouped into flows by the fields they are hashed by, see newFlowKey.
+func (a *analyzer) add(h *tuple_hash.Headers, length uint64) {
+	flow := a.policy.Flow(h)
+	key := newFlowKey(a.policy, &flow)
+	fs, ok := a.flows[key]
+	if !ok {
+		hash := a.policy.Hash64(h)
+		fs = &flowStats{
+			before: a.before.Hash(hash),
+			after:  a.after.Hash(hash),
+		}
+		a.flows[key] = fs
+	}
+	fs.packets++
+	fs.bytes += length
+}
+
+// distribution returns the stats of the backends, including the given ones that received no flow,
+// with backendOf selecting the backend of a flow.
+func (a *analyzer) distribution(backends []string, backendOf func(fs *flowStats) string) map[string]*stats {
+	perBackend := make(map[string]*stats, len(backends))
+	for _, backend := range backends {
+		perBackend[backend] = &stats{}
+	}
+	for _, fs := range a.flows {
+		backend := backendOf(fs)
+		s, ok := perBackend[backend]
+		if !ok {
+			s = &stats{}
+			perBackend[backend] = s
+		}
+		s.packets += fs.packets
+		s.flows++
+		s.bytes += fs.bytes
+	}
+	return perBackend
+}
+
+func (a *analyzer) report(w io.Writer) error {
+	var moved, total stats
+	for _, fs := range a.flows {
+		total.packets += fs.packets
+		total.flows++
+		total.bytes += fs.bytes
+		if fs.before != fs.after {
+			moved.packets += fs.packets
+			moved.flows++
+			moved.bytes += fs.bytes
+		}
+	}
+
+	fmt.Fprintf(w, "packets: %d, hashed: %d, skipped: %d\n\n", a.packets, a.packets-a.skipped, a.skipped)
+	before := a.distribution(a.backendsBefore, func(fs *flowStats) string { return fs.before })
+	if err := writeDistribution(w, before, total); err != nil {
+		return err
+	}
+
+	if !a.changed {
+		return nil
+	}
+	fmt.Fprintf(w, "\nmembership change: added [%s], removed [%s]\n",
+		strings.Join(a.added, ", "), strings.Join(a.removed, ", "))
+	fmt.Fprintf(w, "moved flows: %d (%.2f%%), packets: %d (%.2f%%), bytes: %d (%.2f%%)\n",
+		moved.flows, percent(moved.flows, total.flows),
+		moved.packets, percent(moved.packets, total.packets),
+		moved.bytes, percent(moved.bytes, total.bytes))
+
+	fmt.Fprintf(w, "\nafter the membership change:\n")
+	after := a.distribution(a.backendsAfter, func(fs *flowStats) string { return fs.after })
+	return writeDistribution(w, after, total)
+}
+
+// writeDistribution writes a table of the stats of each backend, sorted by name, and their share of total.
+// Flows hashed to no backend, e.g. when all backends are removed, are listed as "(none)".
+func writeDistribution(w io.Writer, perBackend map[string]*stats, total stats) error {
+	backends := make([]string, 0, len(perBackend))
+	for backend := range perBackend {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "backend\tpackets\t%\tflows\t%\tbytes\t%\t")
+	for _, backend := range backends {
+		s := perBackend[backend]
+		name := backend
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%d\t%.2f\t%d\t%.2f\t\n", name,
+			s.packets, percent(s.packets, total.packets),
+			s.flows, percent(s.flows, total.flows),
+			s.bytes, percent(s.bytes, total.bytes))
+	}
+	return tw.Flush()
+}
+
+func percent(part uint64, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"maps"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"maglev-go/chash"
+	"maglev-go/tuple_hash"
+)
+
+// udpFrame builds an Ethernet frame carrying an IPv4/UDP packet with the given payload length.
+func udpFrame(src netip.Addr, srcPort uint16, dst netip.Addr, dstPort uint16, payloadLen int) []byte {
+	frame := make([]byte, 14+20+8+payloadLen)
+	binary.BigEndian.PutUint16(frame[12:], tuple_hash.EtherTypeIPv4)
+	ip := frame[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)))
+	ip[8] = 64
+	ip[9] = tuple_hash.ProtoUDP
+	src4, dst4 := src.As4(), dst.As4()
+	copy(ip[12:16], src4[:])
+	copy(ip[16:20], dst4[:])
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp[0:], srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	return frame
+}
+
+type capture struct {
+	frames [][]byte
+	// flows is the number of distinct flows in frames.
+	flows int
+}
+
+func newCapture() capture {
+	var c capture
+	dst := netip.MustParseAddr("192.0.2.1")
+	for i := 0; i < 200; i++ {
+		src := netip.AddrFrom4([4]byte{10, 0, byte(i / 256), byte(i % 256)})
+		// 3 packets per flow
+		for j := 0; j < 3; j++ {
+			c.frames = append(c.frames, udpFrame(src, uint16(10000+i), dst, 53, 100))
+		}
+		c.flows++
+	}
+	// ARP is not hashed
+	arp := make([]byte, 42)
+	binary.BigEndian.PutUint16(arp[12:], 0x0806)
+	c.frames = append(c.frames, arp)
+	return c
+}
+
+func writePcap(t *testing.T, c capture) []byte {
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	require.NoError(t, w.WriteFileHeader(65535, layers.LinkTypeEthernet))
+	for _, frame := range c.frames {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(0, 0), CaptureLength: len(frame), Length: len(frame)}
+		require.NoError(t, w.WritePacket(ci, frame))
+	}
+	return buf.Bytes()
+}
+
+func writePcapng(t *testing.T, c capture) []byte {
+	var buf bytes.Buffer
+	w, err := pcapgo.NewNgWriter(&buf, layers.LinkTypeEthernet)
+	require.NoError(t, err)
+	for _, frame := range c.frames {
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(0, 0), CaptureLength: len(frame), Length: len(frame)}
+		require.NoError(t, w.WritePacket(ci, frame))
+	}
+	require.NoError(t, w.Flush())
+	return buf.Bytes()
+}
+
+func TestAnalyzer(t *testing.T) {
+	c := newCapture()
+	backends := []string{"b1", "b2", "b3"}
+
+	for name, data := range map[string][]byte{
+		"pcap":   writePcap(t, c),
+		"pcapng": writePcapng(t, c),
+	} {
+		t.Run(name, func(t *testing.T) {
+			a, err := newAnalyzer(tuple_hash.Policy{}, uint32(chash.SmallSize), backends, []string{"b4"}, nil)
+			require.NoError(t, err)
+			require.NoError(t, a.read(bytes.NewReader(data)))
+
+			assert.Equal(t, uint64(len(c.frames)), a.packets)
+			assert.Equal(t, uint64(1), a.skipped)
+			assert.Len(t, a.flows, c.flows)
+
+			var moved, movedToNew int
+			for _, fs := range a.flows {
+				assert.Equal(t, uint64(3), fs.packets)
+				assert.Equal(t, uint64(3*(14+20+8+100)), fs.bytes)
+				assert.Contains(t, backends, fs.before)
+				if fs.before != fs.after {
+					moved++
+					if fs.after == "b4" {
+						movedToNew++
+					}
+				}
+			}
+			// About a quarter of the flows move, Maglev moves few of them between existing backends
+			assert.InDelta(t, 25, float64(moved)*100/float64(c.flows), 10)
+			assert.GreaterOrEqual(t, movedToNew*10, moved*8)
+		})
+	}
+}
+
+// fragment sets the fragment offset and the MF flag of a frame built by udpFrame.
+func fragment(frame []byte, offset uint16, more bool) []byte {
+	flagsAndOffset := offset / 8
+	if more {
+		flagsAndOffset |= 0x2000
+	}
+	binary.BigEndian.PutUint16(frame[14+6:], flagsAndOffset)
+	return frame
+}
+
+func TestAnalyzerFragments(t *testing.T) {
+	src, dst := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("192.0.2.1")
+	// The ports of non-first fragments are payload, and must not be hashed
+	frames := [][]byte{
+		fragment(udpFrame(src, 10000, dst, 53, 100), 0, true),
+		fragment(udpFrame(src, 0x1234, dst, 0x5678, 100), 128, true),
+		fragment(udpFrame(src, 0x4321, dst, 0x8765, 100), 256, false),
+		// Another fragmented packet of the same 3-tuple
+		fragment(udpFrame(src, 10001, dst, 53, 100), 0, true),
+		// A non-fragmented packet is hashed by 5-tuple
+		udpFrame(src, 10000, dst, 53, 100),
+	}
+
+	a, err := newAnalyzer(tuple_hash.Policy{}, uint32(chash.SmallSize), []string{"b1", "b2", "b3"}, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, a.read(bytes.NewReader(writePcap(t, capture{frames: frames}))))
+
+	require.Len(t, a.flows, 2)
+	for key, fs := range a.flows {
+		switch key.mode {
+		case tuple_hash.Mode3Tuple:
+			assert.Equal(t, uint64(4), fs.packets)
+			assert.Zero(t, key.tuple.SrcPort)
+			assert.Zero(t, key.tuple.DstPort)
+		case tuple_hash.Mode5Tuple:
+			assert.Equal(t, uint64(1), fs.packets)
+		default:
+			t.Errorf("unexpected mode %s", key.mode)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	require.NoError(t, os.WriteFile(path, writePcap(t, newCapture()), 0o600))
+
+	var out bytes.Buffer
+	err := run([]string{"-pcap", path, "-backends", "b1,b2,b3", "-remove", "b2"}, &out)
+	require.NoError(t, err)
+
+	report := out.String()
+	assert.Contains(t, report, "packets: 601, hashed: 600, skipped: 1")
+	for _, backend := range []string{"b1", "b2", "b3"} {
+		assert.Contains(t, report, backend)
+	}
+	assert.Contains(t, report, "membership change: added [], removed [b2]")
+	assert.Contains(t, report, "moved flows: ")
+
+	t.Run("Backends without flows", func(t *testing.T) {
+		src, dst := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("192.0.2.1")
+		single := filepath.Join(t.TempDir(), "single.pcap")
+		require.NoError(t, os.WriteFile(single, writePcap(t, capture{frames: [][]byte{udpFrame(src, 10000, dst, 53, 100)}}), 0o600))
+
+		var out bytes.Buffer
+		err := run([]string{"-pcap", single, "-backends", "b1,b2,b3", "-add", "b4", "-remove", "b1"}, &out)
+		require.NoError(t, err)
+
+		report := strings.SplitN(out.String(), "after the membership change:", 2)
+		require.Len(t, report, 2)
+		before, after := rows(report[0]), rows(report[1])
+		// Backends that receive no flow are listed with zero packets
+		assert.Equal(t, []string{"b1", "b2", "b3"}, slices.Sorted(maps.Keys(before)))
+		assert.Equal(t, []string{"b2", "b3", "b4"}, slices.Sorted(maps.Keys(after)))
+		for _, dist := range []map[string]string{before, after} {
+			var zero int
+			for _, packets := range dist {
+				if packets == "0" {
+					zero++
+				}
+			}
+			assert.Equal(t, 2, zero, report)
+		}
+	})
+
+	t.Run("Invalid arguments", func(t *testing.T) {
+		assert.Error(t, run([]string{"-backends", "b1"}, &out))
+		assert.ErrorIs(t, run([]string{"-pcap", path}, &out), ErrNoBackends)
+		assert.ErrorIs(t, run([]string{"-pcap", path, "-backends", "b1", "-mode", "4-tuple"}, &out), tuple_hash.ErrUnknownHashMode)
+		for _, size := range []string{"0", "65536", "4294967311"} {
+			assert.ErrorIs(t, run([]string{"-pcap", path, "-backends", "b1", "-size", size}, &out), ErrInvalidSize, size)
+		}
+		assert.NoError(t, run([]string{"-pcap", path, "-backends", "b1", "-size", "13"}, &out))
+	})
+}
+
+// rows returns the packets of each backend listed in the distribution tables of a report.
+func rows(report string) map[string]string {
+	packets := make(map[string]string)
+	for _, line := range strings.Split(report, "\n") {
+		if fields := strings.Fields(line); len(fields) == 7 && fields[0] != "backend" {
+			packets[fields[0]] = fields[1]
+		}
+	}
+	return packets
+}
@@ -0,0 +1,117 @@
+package health_monitor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"maglev-go/x/ptr"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAgentDown            = fmt.Errorf("agent reported backend down")
+	ErrInvalidAgentResponse = fmt.Errorf("invalid agent response")
+)
+
+// maxAgentResponseSize is the maximum size of the line read from an agent.
+const maxAgentResponseSize = 1024
+
+type AgentConfig struct {
+	// Send is sent to the agent once connected, e.g. "status\n". If empty, nothing is sent.
+	Send string `mapstructure:"send"`
+}
+
+// agentChecker reports the weight of the backend sent by the agent.
+type agentChecker struct{}
+
+func (agentChecker) Check(ctx context.Context, cfg *BackendConfig) Result {
+	weight, err := doAgent(ctx, cfg)
+	return Result{Weight: weight, Err: err}
+}
+
+// doAgent connects to the agent of the backend, optionally sends AgentConfig.Send,
+// and reads a line terminated by a newline or the end of the connection. See parseAgentResponse.
+func doAgent(ctx context.Context, cfg *BackendConfig) (*int, error) {
+	dialer := net.Dialer{Timeout: cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(cfg.Url.Hostname(), cfg.Url.Port()))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(cfg.Timeout)); err != nil {
+		return nil, err
+	}
+
+	if cfg.Agent.Send != "" {
+		if _, err := io.WriteString(conn, cfg.Agent.Send); err != nil {
+			return nil, err
+		}
+	}
+	line, err := bufio.NewReader(io.LimitReader(conn, maxAgentResponseSize)).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, err
+	}
+	return parseAgentResponse(line)
+}
+
+// parseAgentResponse parses the response of an HAProxy-style agent: words separated by spaces,
+// tabs or commas, optionally followed by a "#" and a description. Words are case-insensitive,
+// and later words override earlier ones:
+//   - "up" or "ready": the backend is healthy with a weight of 100, e.g. after a drain.
+//   - "drain": the backend is healthy with a weight of 0, i.e. it should receive no new connections.
+//   - "down", "fail", "stopped" or "maint": the backend is unhealthy, see ErrAgentDown.
+//   - "75%": the weight of the backend in percent, which takes precedence over "up", "ready" and "drain".
+//
+// Unknown words are ignored, but at least one word must be known.
+// Returns the reported weight, or nil if none is reported.
+func parseAgentResponse(line string) (*int, error) {
+	line, _, _ = strings.Cut(line, "#")
+	words := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ',' || r == '\r' || r == '\n'
+	})
+
+	var (
+		weight  *int
+		percent *int
+		down    string
+		known   bool
+	)
+	for _, word := range words {
+		switch word = strings.ToLower(word); word {
+		case "up", "ready":
+			down = ""
+			weight = ptr.ToPtr(100)
+		case "drain":
+			down = ""
+			weight = ptr.ToPtr(0)
+		case "down", "fail", "stopped", "maint":
+			down = word
+		default:
+			digits, ok := strings.CutSuffix(word, "%")
+			if !ok {
+				continue
+			}
+			n, err := strconv.Atoi(digits)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%w: invalid weight %q", ErrInvalidAgentResponse, word)
+			}
+			percent = ptr.ToPtr(n)
+		}
+		known = true
+	}
+
+	if !known {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAgentResponse, strings.TrimSpace(line))
+	}
+	if percent != nil {
+		weight = percent
+	}
+	if down != "" {
+		return weight, fmt.Errorf("%w: %s", ErrAgentDown, down)
+	}
+	return weight, nil
+}
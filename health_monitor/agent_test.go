@@ -0,0 +1,184 @@
+package health_monitor
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maglev-go/x/ptr"
+)
+
+func TestParseAgentResponse(t *testing.T) {
+	tests := []struct {
+		line       string
+		wantWeight *int
+		wantErr    error
+	}{
+		{line: "up\n", wantWeight: ptr.ToPtr(100)},
+		{line: "READY", wantWeight: ptr.ToPtr(100)},
+		{line: "75%\n", wantWeight: ptr.ToPtr(75)},
+		{line: "up 150%\r\n", wantWeight: ptr.ToPtr(150)},
+		{line: "drain\n", wantWeight: ptr.ToPtr(0)},
+		{line: "drain,50%", wantWeight: ptr.ToPtr(50)},
+		{line: "50% ready", wantWeight: ptr.ToPtr(50)},
+		{line: "drain up", wantWeight: ptr.ToPtr(100)},
+		{line: "down\n", wantErr: ErrAgentDown},
+		{line: "maint #upgrading\n", wantErr: ErrAgentDown},
+		{line: "fail\tstopped", wantErr: ErrAgentDown},
+		{line: "maint up", wantWeight: ptr.ToPtr(100)},
+		{line: "down 10%", wantWeight: ptr.ToPtr(10), wantErr: ErrAgentDown},
+		{line: "up unknown-word", wantWeight: ptr.ToPtr(100)},
+		{line: "\n", wantErr: ErrInvalidAgentResponse},
+		{line: "#only a description", wantErr: ErrInvalidAgentResponse},
+		{line: "hello", wantErr: ErrInvalidAgentResponse},
+		{line: "x%", wantErr: ErrInvalidAgentResponse},
+		{line: "-5%", wantErr: ErrInvalidAgentResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			weight, err := parseAgentResponse(tt.line)
+			assert.Equal(t, tt.wantWeight, weight)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDoAgent(t *testing.T) {
+	// The agent replies to "status\n" with its state, and closes the connection without a newline otherwise
+	agent := newTcpServer(t, func(conn net.Conn) {
+		_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if line, _ := bufio.NewReader(conn).ReadString('\n'); line == "status\n" {
+			_, _ = io.WriteString(conn, "up 60%\n")
+		} else {
+			_, _ = io.WriteString(conn, "drain")
+		}
+	})
+	silent := newTcpServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		cfg := newTestBackend(t, "agent://"+agent, AGENT)
+		cfg.Agent.Send = "status\n"
+		weight, err := doAgent(context.Background(), cfg)
+		require.NoError(t, err)
+		assert.Equal(t, ptr.ToPtr(60), weight)
+	})
+
+	t.Run("Without newline", func(t *testing.T) {
+		cfg := newTestBackend(t, "agent://"+agent, AGENT)
+		weight, err := doAgent(context.Background(), cfg)
+		require.NoError(t, err)
+		assert.Equal(t, ptr.ToPtr(0), weight)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		cfg := newTestBackend(t, "agent://"+silent, AGENT)
+		cfg.Timeout = 200 * time.Millisecond
+		_, err := doAgent(context.Background(), cfg)
+		assert.ErrorContains(t, err, "i/o timeout")
+	})
+
+	t.Run("Composite", func(t *testing.T) {
+		hm, err := NewHealthMonitor(context.Background())
+		require.NoError(t, err)
+		impl := hm.(*healthMonitorImpl)
+
+		status := newTestBackend(t, "agent://"+agent, AGENT)
+		status.Name = "status"
+		status.Agent.Send = "status\n"
+		draining := newTestBackend(t, "agent://"+agent, AGENT)
+		draining.Name = "draining"
+		cfg := newTestBackend(t, "http://"+agent, HTTP)
+		cfg.Probes = []*BackendConfig{status, draining}
+		require.NoError(t, hm.Add(cfg))
+
+		healthy, _ := impl.healthcheck(impl.backends["backend"])
+		assert.True(t, healthy)
+		assert.Equal(t, 60, impl.backends["backend"].probes[0].weight)
+		// The weight of a composite backend is the minimum weight of its probes
+		assert.Equal(t, 0, impl.backends["backend"].toNoti().Weight)
+	})
+
+	t.Run("Drain then ready", func(t *testing.T) {
+		var response atomic.Value
+		response.Store("drain\n")
+		switchable := newTcpServer(t, func(conn net.Conn) {
+			_, _ = io.WriteString(conn, response.Load().(string))
+		})
+
+		hm, err := NewHealthMonitor(context.Background())
+		require.NoError(t, err)
+		impl := hm.(*healthMonitorImpl)
+		require.NoError(t, hm.Add(newTestBackend(t, "agent://"+switchable, AGENT)))
+
+		healthy, _ := impl.healthcheck(impl.backends["backend"])
+		assert.True(t, healthy)
+		assert.Equal(t, 0, impl.backends["backend"].toNoti().Weight)
+
+		// The backend gets its full weight back once the agent reports it ready again
+		response.Store("ready\n")
+		healthy, _ = impl.healthcheck(impl.backends["backend"])
+		assert.True(t, healthy)
+		assert.Equal(t, 100, impl.backends["backend"].toNoti().Weight)
+	})
+
+	t.Run("Connection refused", func(t *testing.T) {
+		cfg := newTestBackend(t, "agent://127.0.0.1:1", AGENT)
+		_, err := doAgent(context.Background(), cfg)
+		assert.Error(t, err)
+	})
+}
+
+func TestHealthMonitorWeight(t *testing.T) {
+	var weight atomic.Int32
+	weight.Store(100)
+	hm, err := NewHealthMonitor(context.Background(),
+		WithCheckInterval(100*time.Millisecond),
+		WithHealthyThreshold(1),
+		EnableHealthyChannel(),
+		WithChecker("test-weight", CheckerFunc(func(context.Context, *BackendConfig) Result {
+			return Result{Weight: ptr.ToPtr(int(weight.Load()))}
+		})),
+	)
+	require.NoError(t, err)
+	healthyChan, err := hm.HealthyChan()
+	require.NoError(t, err)
+
+	require.NoError(t, hm.Add(newTestBackend(t, "weight://backend", "test-weight")))
+	noti := <-healthyChan
+	assert.Equal(t, 100, noti.Weight)
+
+	require.NoError(t, hm.Start())
+	defer hm.Stop()
+	// The first check reaches the healthy threshold
+	noti = <-healthyChan
+	assert.Equal(t, 100, noti.Weight)
+
+	// Healthy backends are notified again when their weight changes
+	weight.Store(0)
+	select {
+	case noti = <-healthyChan:
+		assert.True(t, noti.Healthy)
+		assert.Equal(t, 0, noti.Weight)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no notification of the new weight")
+	}
+
+	select {
+	case noti = <-healthyChan:
+		t.Fatalf("unexpected notification without a new weight: %+v", noti)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
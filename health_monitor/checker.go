@@ -0,0 +1,151 @@
+package health_monitor
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownProtocol = fmt.Errorf("unknown protocol")
+	ErrCheckerExists   = fmt.Errorf("checker already registered")
+)
+
+// Result is the result of a health check.
+type Result struct {
+	// Latency is the time the check took, or a latency measured by the checker, e.g. the ICMP round-trip time.
+	// If zero, the health monitor sets it to the duration of Checker.Check.
+	Latency time.Duration
+	// Err is nil if the backend is healthy.
+	Err error
+	// Weight is the weight of the backend in percent, if reported by the checker, e.g. by an agent.
+	// The health monitor keeps the last reported weight, see HealthNoti.Weight.
+	Weight *int
+}
+
+// Checker checks the health of backends for a protocol. Its implementation must be thread-safe.
+type Checker interface {
+	// Check checks the health of the backend with the given configuration.
+	// It must return within cfg.Timeout, or when the context is done.
+	// The context carries the logger of the backend, see zerolog.Ctx.
+	Check(ctx context.Context, cfg *BackendConfig) Result
+}
+
+// Validator is implemented by checkers that validate backend configurations.
+// Validate is called when a backend is added, after defaults are set. If it returns an error,
+// the backend is not added.
+type Validator interface {
+	Validate(cfg *BackendConfig) error
+}
+
+// CheckerFunc adapts a function to a Checker.
+type CheckerFunc func(ctx context.Context, cfg *BackendConfig) Result
+
+func (f CheckerFunc) Check(ctx context.Context, cfg *BackendConfig) Result {
+	return f(ctx, cfg)
+}
+
+// builtinChecker is a Checker of a built-in protocol.
+type builtinChecker struct {
+	check    func(ctx context.Context, cfg *BackendConfig) error
+	validate func(cfg *BackendConfig) error
+}
+
+func (c builtinChecker) Check(ctx context.Context, cfg *BackendConfig) Result {
+	return Result{Err: c.check(ctx, cfg)}
+}
+
+func (c builtinChecker) Validate(cfg *BackendConfig) error {
+	if c.validate == nil {
+		return nil
+	}
+	return c.validate(cfg)
+}
+
+// icmpChecker reports the average round-trip time as latency.
+type icmpChecker struct{}
+
+func (icmpChecker) Check(ctx context.Context, cfg *BackendConfig) Result {
+	stats, err := doIcmp(ctx, cfg)
+	zerolog.Ctx(ctx).Debug().
+		Int("sent", stats.sent).
+		Int("received", stats.received).
+		Float64("loss", stats.loss()).
+		Dur("rtt_min", stats.minRTT).
+		Dur("rtt_avg", stats.avgRTT).
+		Dur("rtt_max", stats.maxRTT).
+		Msg("ICMP echo statistics")
+	return Result{Latency: stats.avgRTT, Err: err}
+}
+
+func (icmpChecker) Validate(cfg *BackendConfig) error {
+	return cfg.ICMP.validate()
+}
+
+var (
+	httpChecker = builtinChecker{
+		check:    doHttp,
+		validate: func(cfg *BackendConfig) error { return cfg.HTTP.validate() },
+	}
+
+	checkers = map[Protocol]Checker{
+		HTTP:  httpChecker,
+		HTTPS: httpChecker,
+		TCP: builtinChecker{
+			check:    doTcp,
+			validate: func(cfg *BackendConfig) error { return cfg.TCP.validate() },
+		},
+		ICMP: icmpChecker{},
+		GRPC: builtinChecker{check: doGrpc},
+		UDP: builtinChecker{
+			check:    doUdp,
+			validate: func(cfg *BackendConfig) error { return cfg.UDP.validate() },
+		},
+		DNS: builtinChecker{
+			check:    doDns,
+			validate: func(cfg *BackendConfig) error { return cfg.DNS.validate() },
+		},
+		AGENT: agentChecker{},
+		EXEC: builtinChecker{
+			check:    doExec,
+			validate: func(cfg *BackendConfig) error { return cfg.Exec.validate() },
+		},
+	}
+	checkersMtx sync.RWMutex
+)
+
+// RegisterChecker registers the checker of a custom protocol for all health monitors,
+// so that backends can reference it by name, including from configuration files.
+// It returns ErrCheckerExists if the protocol is already registered, including built-in protocols.
+// Use WithChecker to override a protocol for a single health monitor.
+func RegisterChecker(protocol Protocol, checker Checker) error {
+	checkersMtx.Lock()
+	defer checkersMtx.Unlock()
+
+	if _, ok := checkers[protocol]; ok {
+		return fmt.Errorf("%w: %s", ErrCheckerExists, protocol)
+	}
+	checkers[protocol] = checker
+	return nil
+}
+
+// unregisterChecker removes the checker of a custom protocol registered with RegisterChecker, e.g. in tests.
+func unregisterChecker(protocol Protocol) {
+	checkersMtx.Lock()
+	defer checkersMtx.Unlock()
+
+	delete(checkers, protocol)
+}
+
+// lookupChecker returns the registered checker of the protocol.
+func lookupChecker(protocol Protocol) (Checker, error) {
+	checkersMtx.RLock()
+	defer checkersMtx.RUnlock()
+
+	if checker, ok := checkers[protocol]; ok {
+		return checker, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownProtocol, protocol)
+}
@@ -0,0 +1,111 @@
+package health_monitor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// optionsChecker is healthy if the "healthy" option of the backend is true.
+type optionsChecker struct {
+	calls atomic.Int32
+}
+
+func (c *optionsChecker) Check(_ context.Context, cfg *BackendConfig) Result {
+	c.calls.Add(1)
+	if healthy, _ := cfg.Options["healthy"].(bool); !healthy {
+		return Result{Err: fmt.Errorf("backend reported unhealthy")}
+	}
+	return Result{Latency: time.Millisecond}
+}
+
+func (c *optionsChecker) Validate(cfg *BackendConfig) error {
+	if _, ok := cfg.Options["healthy"]; !ok {
+		return fmt.Errorf("option healthy is required")
+	}
+	return nil
+}
+
+func TestRegisterChecker(t *testing.T) {
+	checker := &optionsChecker{}
+	require.NoError(t, RegisterChecker("test-options", checker))
+	t.Cleanup(func() { unregisterChecker("test-options") })
+	assert.ErrorIs(t, RegisterChecker("test-options", checker), ErrCheckerExists)
+	assert.ErrorIs(t, RegisterChecker(HTTP, checker), ErrCheckerExists)
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	require.NoError(t, v.ReadConfig(strings.NewReader(`
+interval: 100ms
+unhealthy_threshold: 1
+backends:
+  - name: up
+    url: custom://up
+    protocol: test-options
+    options:
+      healthy: true
+  - name: down
+    url: custom://down
+    protocol: test-options
+    options:
+      healthy: false
+`)))
+	hm, err := NewHealthMonitor(context.Background(), LoadConfig(v))
+	require.NoError(t, err)
+	require.NoError(t, hm.Start())
+	defer hm.Stop()
+
+	assert.Eventually(t, func() bool { return !hm.IsHealthy("down") }, 2*time.Second, 50*time.Millisecond)
+	assert.True(t, hm.IsHealthy("up"))
+	assert.GreaterOrEqual(t, checker.calls.Load(), int32(2))
+
+	t.Run("Validation", func(t *testing.T) {
+		cfg := newTestBackend(t, "custom://invalid", "test-options")
+		cfg.Name = "invalid"
+		assert.ErrorContains(t, hm.Add(cfg), "option healthy is required")
+		assert.Equal(t, 2, hm.Size())
+	})
+
+	t.Run("Unknown protocol", func(t *testing.T) {
+		cfg := newTestBackend(t, "custom://unknown", "test-unknown")
+		cfg.Name = "unknown"
+		assert.ErrorIs(t, hm.Add(cfg), ErrUnknownProtocol)
+	})
+}
+
+func TestWithChecker(t *testing.T) {
+	var calls atomic.Int32
+	hm, err := NewHealthMonitor(context.Background(),
+		WithCheckInterval(100*time.Millisecond),
+		WithUnhealthyThreshold(1),
+		WithChecker(HTTP, CheckerFunc(func(context.Context, *BackendConfig) Result {
+			calls.Add(1)
+			return Result{}
+		})),
+		WithChecker("test-with-checker", CheckerFunc(func(context.Context, *BackendConfig) Result {
+			return Result{}
+		})),
+	)
+	require.NoError(t, err)
+
+	// Nothing listens on the backend, the checker of the monitor overrides the built-in one
+	require.NoError(t, hm.Add(
+		newTestBackend(t, "http://127.0.0.1:1/health", HTTP),
+	))
+	require.NoError(t, hm.Start())
+	defer hm.Stop()
+
+	assert.Eventually(t, func() bool { return calls.Load() >= 2 }, 2*time.Second, 50*time.Millisecond)
+	assert.True(t, hm.IsHealthy("backend"))
+
+	// Protocols of a monitor are not registered globally
+	_, err = lookupChecker("test-with-checker")
+	assert.ErrorIs(t, err, ErrUnknownProtocol)
+}
@@ -1,8 +1,14 @@
 package health_monitor
 
 import (
+	"encoding/hex"
+	"fmt"
 	"github.com/rs/zerolog"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,7 +33,8 @@ type Config struct {
 	// HealthyInitially is the initial state of the backend.
 	// If true, the backend is assumed to be healthy when first added.
 	HealthyInitially bool `mapstructure:"healthy_initially" default:"true"`
-	// TODO: SSL configuration
+	// TLS is the TLS configuration of https health checks.
+	TLS TLSConfig `mapstructure:"tls"`
 
 	// Runtime configuration
 	// EnableHealthyChannel enables sending to channel when a new backend becomes healthy.
@@ -36,6 +43,8 @@ type Config struct {
 	EnableUnhealthyChannel bool `mapstructure:"send_new_unhealthy" default:"false"`
 
 	logger zerolog.Logger
+	// checkers overrides the registered checkers for this health monitor.
+	checkers map[Protocol]Checker
 }
 
 type BackendConfig struct {
@@ -44,6 +53,7 @@ type BackendConfig struct {
 	// Url is the URL with healthcheck path of this backend.
 	Url url.URL `mapstructure:"url"`
 	// Protocol is the protocol to use for health checks. Default is "http".
+	// Custom protocols can be registered with RegisterChecker or WithChecker.
 	Protocol Protocol `mapstructure:"protocol" default:"http"`
 	// Timeout overrides the global timeout for this backend.
 	// If the timeout is greater than 2/3 the global interval, the timeout is set to 2/3 the interval
@@ -55,6 +65,153 @@ type BackendConfig struct {
 	UnhealthyThreshold int `mapstructure:"unhealthy_threshold"`
 	// HealthyThreshold overrides the global healthy threshold for this backend.
 	HealthyThreshold int `mapstructure:"healthy_threshold"`
+	// TLS overrides the global TLS configuration for this backend. Fields that are not set inherit the global
+	// values, the client certificate and key together.
+	TLS *TLSConfig `mapstructure:"tls"`
+	// HTTP configures the request and response checks of the http and https protocols.
+	HTTP HTTPConfig `mapstructure:"http"`
+	// GRPC configures the grpc protocol.
+	GRPC GRPCConfig `mapstructure:"grpc"`
+	// ICMP configures the icmp protocol.
+	ICMP ICMPConfig `mapstructure:"icmp"`
+	// TCP configures the tcp protocol.
+	TCP TCPConfig `mapstructure:"tcp"`
+	// UDP configures the udp protocol.
+	UDP UDPConfig `mapstructure:"udp"`
+	// DNS configures the dns protocol.
+	DNS DNSConfig `mapstructure:"dns"`
+	// Exec configures the exec protocol.
+	Exec ExecConfig `mapstructure:"exec"`
+	// Agent configures the agent protocol.
+	Agent AgentConfig `mapstructure:"agent"`
+	// Options configures custom protocols. Keys are lowercase when loaded with LoadConfig.
+	// Checkers may decode it into their own configuration with mapstructure.
+	Options map[string]interface{} `mapstructure:"options"`
+	// Probes are the health checks of a composite backend, which replace the check of its own protocol.
+	// Each probe has its own streaks and thresholds, and the backend is healthy if enough probes
+	// are healthy, see Require. A probe inherits the URL, timeout, accepted status codes, thresholds
+	// and TLS configuration of the backend. Its name defaults to its protocol, and must be unique.
+	Probes []*BackendConfig `mapstructure:"probes"`
+	// Require is the number of probes that must be healthy for the backend to be healthy:
+	// "all", "any" or a number. Default is "all".
+	Require string `mapstructure:"require" default:"all"`
+}
+
+// requiredProbes returns the number of probes that must be healthy, see Require.
+func (c *BackendConfig) requiredProbes() (int, error) {
+	switch strings.ToLower(c.Require) {
+	case "all":
+		return len(c.Probes), nil
+	case "any":
+		return 1, nil
+	}
+	n, err := strconv.Atoi(c.Require)
+	if err != nil || n < 1 || n > len(c.Probes) {
+		return 0, fmt.Errorf("require must be all, any or a number between 1 and %d, got %q", len(c.Probes), c.Require)
+	}
+	return n, nil
+}
+
+type HTTPConfig struct {
+	// Method is the HTTP method of the health check request. Default is "GET".
+	Method string `mapstructure:"method" default:"GET"`
+	// Headers are added to the health check request.
+	// A "Host" header is treated like Host.
+	Headers map[string]string `mapstructure:"headers"`
+	// Host overrides the Host header, which is the host of the URL by default.
+	Host string `mapstructure:"host"`
+	// Body is the body of the health check request.
+	Body string `mapstructure:"body"`
+	// ExpectBody is a regex pattern that must match a part of the response body.
+	ExpectBody string `mapstructure:"expect_body"`
+	// ExpectJSON is a list of JSON-path assertions that must all hold for the response body,
+	// e.g. `$.status == "UP"`, `$.checks[0].healthy != false`, or `$.version` to only require the field.
+	// The right-hand side is a JSON value.
+	ExpectJSON []string `mapstructure:"expect_json"`
+}
+
+// validate returns an error if a pattern or an assertion of the config is invalid.
+func (c *HTTPConfig) validate() error {
+	// HEAD responses have no body to check
+	if strings.EqualFold(c.Method, http.MethodHead) && (c.ExpectBody != "" || len(c.ExpectJSON) > 0) {
+		return fmt.Errorf("expect_body and expect_json cannot be used with method HEAD")
+	}
+	if c.ExpectBody != "" {
+		if _, err := regexp.Compile(c.ExpectBody); err != nil {
+			return fmt.Errorf("invalid expect_body: %w", err)
+		}
+	}
+	for _, expr := range c.ExpectJSON {
+		if _, err := parseJSONAssertion(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type GRPCConfig struct {
+	// Service is the name of the service to check. If empty, the overall health of the server is checked.
+	Service string `mapstructure:"service"`
+	// TLS enables TLS, configured by the TLS configuration of the backend. Default is plaintext.
+	TLS bool `mapstructure:"tls"`
+}
+
+type TCPConfig struct {
+	// Send is sent to the backend once connected, e.g. "PING\r\n".
+	// If empty, nothing is sent, which is useful to check a banner.
+	Send string `mapstructure:"send"`
+	// Expect is a regex pattern that must match a part of the data received from the backend,
+	// e.g. "^\\+PONG" or "^220 ". If empty, the check only connects and sends.
+	Expect string `mapstructure:"expect"`
+	// ReadTimeout is the time to wait for the expected data after sending.
+	// It is capped by the timeout of the backend, which is also the default.
+	ReadTimeout time.Duration `mapstructure:"read_timeout"`
+}
+
+// validate returns an error if the pattern of the config is invalid.
+func (c *TCPConfig) validate() error {
+	if _, err := regexp.Compile(c.Expect); err != nil {
+		return fmt.Errorf("invalid tcp expect: %w", err)
+	}
+	return nil
+}
+
+type UDPConfig struct {
+	// Payload is the text sent in the request datagram.
+	Payload string `mapstructure:"payload"`
+	// PayloadHex is the hex-encoded payload of the request datagram, for binary protocols.
+	// Exclusive with Payload.
+	PayloadHex string `mapstructure:"payload_hex"`
+	// Expect is a regex pattern that must match a part of the response datagram.
+	// If neither Expect nor ExpectHex is set, any response is healthy.
+	Expect string `mapstructure:"expect"`
+	// ExpectHex is the hex-encoded prefix the response datagram must start with, for binary protocols.
+	ExpectHex string `mapstructure:"expect_hex"`
+}
+
+// payload returns the request datagram.
+func (c *UDPConfig) payload() ([]byte, error) {
+	if c.PayloadHex != "" {
+		return hex.DecodeString(c.PayloadHex)
+	}
+	return []byte(c.Payload), nil
+}
+
+// validate returns an error if the payload or a pattern of the config is invalid.
+func (c *UDPConfig) validate() error {
+	if c.Payload != "" && c.PayloadHex != "" {
+		return fmt.Errorf("udp payload and payload_hex are exclusive")
+	}
+	if _, err := hex.DecodeString(c.PayloadHex); err != nil {
+		return fmt.Errorf("invalid udp payload_hex: %w", err)
+	}
+	if _, err := hex.DecodeString(c.ExpectHex); err != nil {
+		return fmt.Errorf("invalid udp expect_hex: %w", err)
+	}
+	if _, err := regexp.Compile(c.Expect); err != nil {
+		return fmt.Errorf("invalid udp expect: %w", err)
+	}
+	return nil
 }
 
 type Protocol string
@@ -64,4 +221,19 @@ const (
 	HTTPS Protocol = "https"
 	TCP   Protocol = "tcp"
 	ICMP  Protocol = "icmp"
+	// GRPC calls the standard gRPC health checking service, grpc.health.v1.Health/Check.
+	// The URL only needs a host and a port, e.g. grpc://backend:50051.
+	GRPC Protocol = "grpc"
+	// UDP sends a datagram to the backend and expects a response.
+	// ICMP port unreachable errors and timeouts are failures.
+	UDP Protocol = "udp"
+	// DNS sends a DNS query to the backend and validates the response code and answers.
+	// The URL only needs a host and an optional port, e.g. dns://10.0.0.53.
+	DNS Protocol = "dns"
+	// EXEC runs a command, and the backend is healthy if it exits with code 0.
+	// The URL only provides the host and port substituted in the command, e.g. exec://10.0.0.8:1521.
+	EXEC Protocol = "exec"
+	// AGENT reads the state and weight of the backend from an HAProxy-style agent, e.g. agent://10.0.0.8:9999.
+	// The agent replies "up", "down", "drain", "maint" or a weight like "75%", see parseAgentResponse.
+	AGENT Protocol = "agent"
 )
@@ -0,0 +1,246 @@
+package health_monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnexpectedRcode = fmt.Errorf("unexpected rcode")
+	ErrAnswerMismatch  = fmt.Errorf("no answer matches")
+)
+
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+var dnsRcodes = map[dnsmessage.RCode]string{
+	dnsmessage.RCodeSuccess:        "NOERROR",
+	dnsmessage.RCodeFormatError:    "FORMERR",
+	dnsmessage.RCodeServerFailure:  "SERVFAIL",
+	dnsmessage.RCodeNameError:      "NXDOMAIN",
+	dnsmessage.RCodeNotImplemented: "NOTIMP",
+	dnsmessage.RCodeRefused:        "REFUSED",
+}
+
+type DNSConfig struct {
+	// Name is the queried domain name, e.g. "example.com".
+	Name string `mapstructure:"name"`
+	// Type is the query type: A, AAAA, CNAME, MX, NS, PTR, SOA, SRV or TXT. Default is "A".
+	Type string `mapstructure:"type" default:"A"`
+	// Transport is "udp" or "tcp". Default is "udp".
+	Transport string `mapstructure:"transport" default:"udp"`
+	// AcceptRcodes is the list of accepted response codes, e.g. NOERROR, NXDOMAIN, SERVFAIL.
+	// Default is ["NOERROR"].
+	AcceptRcodes []string `mapstructure:"accept_rcodes" default:"[\"NOERROR\"]"`
+	// Expect is a regex pattern that must match at least one answer, formatted as text:
+	// the address for A and AAAA, the name for CNAME, NS and PTR, "preference host" for MX,
+	// "priority weight port target" for SRV, "ns mbox serial" for SOA and the joined strings for TXT.
+	// If empty, the answers are not checked.
+	Expect string `mapstructure:"expect"`
+}
+
+// validate returns an error if the query or a pattern of the config is invalid.
+func (c *DNSConfig) validate() error {
+	if _, err := c.question(); err != nil {
+		return err
+	}
+	if c.Transport != "udp" && c.Transport != "tcp" {
+		return fmt.Errorf("dns transport must be udp or tcp, got %q", c.Transport)
+	}
+	for _, rcode := range c.AcceptRcodes {
+		if !isDNSRcode(rcode) {
+			return fmt.Errorf("unknown dns rcode %q", rcode)
+		}
+	}
+	if _, err := regexp.Compile(c.Expect); err != nil {
+		return fmt.Errorf("invalid dns expect: %w", err)
+	}
+	return nil
+}
+
+func isDNSRcode(name string) bool {
+	for _, rcode := range dnsRcodes {
+		if strings.EqualFold(rcode, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *DNSConfig) question() (dnsmessage.Question, error) {
+	typ, ok := dnsTypes[strings.ToUpper(c.Type)]
+	if !ok {
+		return dnsmessage.Question{}, fmt.Errorf("unknown dns type %q", c.Type)
+	}
+	fqdn := c.Name
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil || c.Name == "" {
+		return dnsmessage.Question{}, fmt.Errorf("invalid dns name %q", c.Name)
+	}
+	return dnsmessage.Question{Name: name, Type: typ, Class: dnsmessage.ClassINET}, nil
+}
+
+// doDns sends the configured query to the backend and validates the rcode and the answers.
+// The backend URL port defaults to 53.
+func doDns(ctx context.Context, cfg *BackendConfig) error {
+	q, err := cfg.DNS.question()
+	if err != nil {
+		return err
+	}
+	id := uint16(rand.Intn(0x10000))
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{q},
+	}).Pack()
+	if err != nil {
+		return err
+	}
+
+	port := cfg.Url.Port()
+	if port == "" {
+		port = "53"
+	}
+	dialer := net.Dialer{Timeout: cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, cfg.DNS.Transport, net.JoinHostPort(cfg.Url.Hostname(), port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(cfg.Timeout)); err != nil {
+		return err
+	}
+
+	var resp dnsmessage.Message
+	if cfg.DNS.Transport == "tcp" {
+		err = exchangeTCP(conn, query, id, &resp)
+	} else {
+		err = exchangeUDP(conn, query, id, &resp)
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Truncated {
+		return fmt.Errorf("truncated dns response, use tcp")
+	}
+
+	rcode, ok := dnsRcodes[resp.RCode]
+	if !ok {
+		rcode = resp.RCode.String()
+	}
+	accepted := false
+	for _, name := range cfg.DNS.AcceptRcodes {
+		if strings.EqualFold(name, rcode) {
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		return fmt.Errorf("%w: %s", ErrUnexpectedRcode, rcode)
+	}
+
+	if cfg.DNS.Expect == "" {
+		return nil
+	}
+	re, err := regexp.Compile(cfg.DNS.Expect)
+	if err != nil {
+		return err
+	}
+	for _, answer := range resp.Answers {
+		if re.MatchString(formatDNSResource(answer.Body)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrAnswerMismatch, cfg.DNS.Expect)
+}
+
+// exchangeUDP sends the query and reads datagrams until the response with the given ID.
+func exchangeUDP(conn net.Conn, query []byte, id uint16, resp *dnsmessage.Message) error {
+	if _, err := conn.Write(query); err != nil {
+		return err
+	}
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		// Ignore stray and spoofed datagrams
+		if err := resp.Unpack(buf[:n]); err != nil || !resp.Response || resp.ID != id {
+			continue
+		}
+		return nil
+	}
+}
+
+// exchangeTCP sends the query and reads the response with the given ID, both prefixed by their 2-byte length.
+func exchangeTCP(conn net.Conn, query []byte, id uint16, resp *dnsmessage.Message) error {
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if err := resp.Unpack(buf); err != nil {
+		return err
+	}
+	if !resp.Response || resp.ID != id {
+		return fmt.Errorf("%w: dns response id %d, expected %d", ErrResponseMismatch, resp.ID, id)
+	}
+	return nil
+}
+
+// formatDNSResource formats the data of a resource record as text, see DNSConfig.Expect.
+func formatDNSResource(body dnsmessage.ResourceBody) string {
+	switch r := body.(type) {
+	case *dnsmessage.AResource:
+		return netip.AddrFrom4(r.A).String()
+	case *dnsmessage.AAAAResource:
+		return netip.AddrFrom16(r.AAAA).String()
+	case *dnsmessage.CNAMEResource:
+		return r.CNAME.String()
+	case *dnsmessage.NSResource:
+		return r.NS.String()
+	case *dnsmessage.PTRResource:
+		return r.PTR.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", r.Pref, r.MX)
+	case *dnsmessage.SRVResource:
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d", r.NS, r.MBox, r.Serial)
+	case *dnsmessage.TXTResource:
+		return strings.Join(r.TXT, "")
+	default:
+		return ""
+	}
+}
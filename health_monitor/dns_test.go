@@ -0,0 +1,203 @@
+package health_monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/creasty/defaults"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsZone answers queries of a small in-memory zone.
+// Unknown names get NXDOMAIN, and "fail.example.com." gets SERVFAIL.
+func dnsZone(query []byte) []byte {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil || len(req.Questions) != 1 {
+		return nil
+	}
+	q := req.Questions[0]
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: req.ID, Response: true, Authoritative: true},
+		Questions: req.Questions,
+	}
+	hdr := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+
+	switch q.Name.String() {
+	case "example.com.":
+		switch q.Type {
+		case dnsmessage.TypeA:
+			resp.Answers = []dnsmessage.Resource{
+				{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 10}}},
+				{Header: hdr, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 11}}},
+			}
+		case dnsmessage.TypeTXT:
+			resp.Answers = []dnsmessage.Resource{
+				{Header: hdr, Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 ", "-all"}}},
+			}
+		case dnsmessage.TypeMX:
+			resp.Answers = []dnsmessage.Resource{
+				{Header: hdr, Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mail.example.com.")}},
+			}
+		}
+	case "fail.example.com.":
+		resp.RCode = dnsmessage.RCodeServerFailure
+	default:
+		resp.RCode = dnsmessage.RCodeNameError
+	}
+
+	packed, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
+}
+
+// newDnsServer serves dnsZone over UDP and TCP on the same port.
+func newDnsServer(t *testing.T) string {
+	udp := newUdpServer(t, dnsZone)
+	tcp, err := net.Listen("tcp", udp)
+	if err != nil {
+		t.Skipf("TCP port of %s is taken: %v", udp, err)
+	}
+	t.Cleanup(func() { _ = tcp.Close() })
+
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := dnsZone(query)
+				binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+				_, _ = conn.Write(append(length[:], resp...))
+			}()
+		}
+	}()
+	return udp
+}
+
+func TestDoDns(t *testing.T) {
+	addr := newDnsServer(t)
+
+	tests := []struct {
+		name    string
+		cfg     DNSConfig
+		wantErr error
+	}{
+		{
+			name: "A",
+			cfg:  DNSConfig{Name: "example.com"},
+		},
+		{
+			name: "A with expected answer",
+			cfg:  DNSConfig{Name: "example.com.", Expect: `^192\.0\.2\.11$`},
+		},
+		{
+			name:    "A with unexpected answer",
+			cfg:     DNSConfig{Name: "example.com", Expect: `^192\.0\.2\.12$`},
+			wantErr: ErrAnswerMismatch,
+		},
+		{
+			name: "TXT",
+			cfg:  DNSConfig{Name: "example.com", Type: "txt", Expect: "^v=spf1 -all$"},
+		},
+		{
+			name: "MX",
+			cfg:  DNSConfig{Name: "example.com", Type: "MX", Expect: "^10 mail.example.com.$"},
+		},
+		{
+			name: "No answers",
+			cfg:  DNSConfig{Name: "example.com", Type: "AAAA"},
+		},
+		{
+			name:    "NXDOMAIN",
+			cfg:     DNSConfig{Name: "missing.example.com"},
+			wantErr: ErrUnexpectedRcode,
+		},
+		{
+			name: "Accepted NXDOMAIN",
+			cfg:  DNSConfig{Name: "missing.example.com", AcceptRcodes: []string{"NOERROR", "nxdomain"}},
+		},
+		{
+			name:    "SERVFAIL",
+			cfg:     DNSConfig{Name: "fail.example.com"},
+			wantErr: ErrUnexpectedRcode,
+		},
+	}
+
+	for _, transport := range []string{"udp", "tcp"} {
+		for _, tt := range tests {
+			t.Run(transport+"/"+tt.name, func(t *testing.T) {
+				cfg := newTestBackend(t, "dns://"+addr, DNS)
+				cfg.DNS = tt.cfg
+				cfg.DNS.Transport = transport
+				require.NoError(t, defaults.Set(cfg))
+				require.NoError(t, cfg.DNS.validate())
+
+				err := doDns(context.Background(), cfg)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+				} else {
+					assert.NoError(t, err)
+				}
+			})
+		}
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		silent := newUdpServer(t, func([]byte) []byte { return nil })
+		cfg := newTestBackend(t, "dns://"+silent, DNS)
+		cfg.Timeout = 200 * time.Millisecond
+		cfg.DNS.Name = "example.com"
+		assert.ErrorContains(t, doDns(context.Background(), cfg), "i/o timeout")
+	})
+
+	t.Run("TCP response ID mismatch", func(t *testing.T) {
+		spoofed := newTcpServer(t, func(conn net.Conn) {
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return
+			}
+			query := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+			resp := dnsZone(query)
+			resp[0] ^= 0xff
+			binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+			_, _ = conn.Write(append(length[:], resp...))
+		})
+		cfg := newTestBackend(t, "dns://"+spoofed, DNS)
+		cfg.DNS = DNSConfig{Name: "example.com", Transport: "tcp"}
+		require.NoError(t, defaults.Set(cfg))
+		assert.ErrorIs(t, doDns(context.Background(), cfg), ErrResponseMismatch)
+	})
+
+	t.Run("Invalid config", func(t *testing.T) {
+		for _, cfg := range []DNSConfig{
+			{Type: "A", Transport: "udp"},
+			{Name: "example.com", Type: "ANY", Transport: "udp"},
+			{Name: "example.com", Type: "A", Transport: "quic"},
+			{Name: "example.com", Type: "A", Transport: "udp", AcceptRcodes: []string{"OK"}},
+			{Name: "example.com", Type: "A", Transport: "udp", Expect: "("},
+		} {
+			assert.Error(t, cfg.validate(), "%+v", cfg)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package health_monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrCommandFailed = fmt.Errorf("command failed")
+
+// maxOutputSize is the maximum size of the output of a command kept for diagnostics.
+const maxOutputSize = 4 * 1024
+
+// execWaitDelay is the time to wait for the output of a killed command, e.g. held open by a daemonized child.
+const execWaitDelay = 100 * time.Millisecond
+
+type ExecConfig struct {
+	// Command is the path or name of the executable, looked up in PATH.
+	Command string `mapstructure:"command"`
+	// Args are the arguments of the command.
+	Args []string `mapstructure:"args"`
+	// Env is a list of "KEY=value" entries added to the environment of the health monitor.
+	// BACKEND_NAME, BACKEND_HOST and BACKEND_PORT are always set.
+	Env []string `mapstructure:"env"`
+	// Dir is the working directory of the command. Default is the working directory of the health monitor.
+	Dir string `mapstructure:"dir"`
+}
+
+// validate returns an error if the command or an environment entry of the config is invalid.
+func (c *ExecConfig) validate() error {
+	if c.Command == "" {
+		return fmt.Errorf("exec command is required")
+	}
+	for _, kv := range c.Env {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			return fmt.Errorf("invalid exec env %q, expected KEY=value", kv)
+		}
+	}
+	return nil
+}
+
+// doExec runs the configured command and returns an error if it does not exit with code 0 within the timeout.
+// The placeholders {name}, {host} and {port} in the command, arguments and environment are replaced
+// by the name of the backend and the host and port of its URL.
+// On timeout or cancellation of ctx, the process group of the command is killed, including its children.
+func doExec(parent context.Context, cfg *BackendConfig) error {
+	replacer := strings.NewReplacer(
+		"{name}", cfg.Name,
+		"{host}", cfg.Url.Hostname(),
+		"{port}", cfg.Url.Port(),
+	)
+	args := make([]string, len(cfg.Exec.Args))
+	for i, arg := range cfg.Exec.Args {
+		args[i] = replacer.Replace(arg)
+	}
+	env := append(os.Environ(),
+		"BACKEND_NAME="+cfg.Name,
+		"BACKEND_HOST="+cfg.Url.Hostname(),
+		"BACKEND_PORT="+cfg.Url.Port(),
+	)
+	for _, kv := range cfg.Exec.Env {
+		env = append(env, replacer.Replace(kv))
+	}
+
+	ctx, cancel := context.WithTimeout(parent, cfg.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, replacer.Replace(cfg.Exec.Command), args...)
+	cmd.Env = env
+	cmd.Dir = cfg.Exec.Dir
+	cmd.WaitDelay = execWaitDelay
+	setProcessGroup(cmd)
+
+	output := &limitedBuffer{limit: maxOutputSize}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	// A command that exits 0 just before the deadline succeeds, the context is only the cause of a failure
+	if err := cmd.Run(); err != nil {
+		if parent.Err() != nil {
+			return fmt.Errorf("%w: killed on cancellation: %w, output: %q", ErrCommandFailed, parent.Err(), output)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: killed after %s: %w, output: %q", ErrCommandFailed, cfg.Timeout, ctx.Err(), output)
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%w: exit code %d, output: %q", ErrCommandFailed, exitErr.ExitCode(), output)
+		}
+		return err
+	}
+	zerolog.Ctx(ctx).Trace().Str("output", output.String()).Msg("Command succeeded")
+	return nil
+}
+
+// limitedBuffer keeps the first bytes written to it up to its limit, and discards the rest.
+// Writes never fail, so that the command is not blocked or killed by a closed pipe.
+type limitedBuffer struct {
+	mtx       sync.Mutex
+	buf       []byte
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if n := b.limit - len(b.buf); len(p) > n {
+		b.buf = append(b.buf, p[:n]...)
+		b.truncated = true
+	} else {
+		b.buf = append(b.buf, p...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, suffixed by "..." if it was truncated.
+func (b *limitedBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.truncated {
+		return string(b.buf) + "..."
+	}
+	return string(b.buf)
+}
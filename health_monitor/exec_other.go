@@ -0,0 +1,9 @@
+//go:build !unix
+
+package health_monitor
+
+import "os/exec"
+
+// setProcessGroup is a no-op without process groups: only the command is killed
+// when the context of the command is done, not its children.
+func setProcessGroup(*exec.Cmd) {}
@@ -0,0 +1,122 @@
+package health_monitor
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoExec(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	tests := []struct {
+		name       string
+		cfg        ExecConfig
+		wantErr    error
+		wantOutput string
+	}{
+		{
+			name: "Exit 0",
+			cfg:  ExecConfig{Command: "sh", Args: []string{"-c", "exit 0"}},
+		},
+		{
+			name:       "Exit 3",
+			cfg:        ExecConfig{Command: "sh", Args: []string{"-c", "echo down >&2; exit 3"}},
+			wantErr:    ErrCommandFailed,
+			wantOutput: `exit code 3, output: "down\n"`,
+		},
+		{
+			name: "Substitution",
+			cfg: ExecConfig{
+				Command: "sh",
+				Args:    []string{"-c", `test "$1:$2" = 127.0.0.1:8080 -a "$HOST" = 127.0.0.1 -a "$BACKEND_PORT" = 8080`, "check", "{host}", "{port}"},
+				Env:     []string{"HOST={host}"},
+			},
+		},
+		{
+			name:       "Truncated output",
+			cfg:        ExecConfig{Command: "sh", Args: []string{"-c", "yes | head -c 100000; exit 1"}},
+			wantErr:    ErrCommandFailed,
+			wantOutput: strings.Repeat(`y\n`, maxOutputSize/2) + `..."`,
+		},
+		{
+			name:       "Missing command",
+			cfg:        ExecConfig{Command: "./missing-health-check"},
+			wantOutput: "no such file or directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestBackend(t, "exec://127.0.0.1:8080", EXEC)
+			cfg.Exec = tt.cfg
+			require.NoError(t, cfg.Exec.validate())
+
+			err := doExec(context.Background(), cfg)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			if tt.wantOutput != "" {
+				assert.ErrorContains(t, err, tt.wantOutput)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("Timeout kills process group", func(t *testing.T) {
+		cfg := newTestBackend(t, "exec://127.0.0.1:8080", EXEC)
+		cfg.Timeout = 200 * time.Millisecond
+		// The child outlives the timeout, and only writes the file if it was not killed with the command
+		alive := filepath.Join(t.TempDir(), "alive")
+		cfg.Exec = ExecConfig{
+			Command: "sh",
+			Args:    []string{"-c", `echo started; (sleep 0.6; touch "$1") & wait`, "check", alive},
+		}
+
+		start := time.Now()
+		err := doExec(context.Background(), cfg)
+		assert.ErrorIs(t, err, ErrCommandFailed)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorContains(t, err, "killed after 200ms")
+		assert.ErrorContains(t, err, `output: "started\n"`)
+		// The command does not wait for its child
+		assert.Less(t, time.Since(start), 600*time.Millisecond)
+
+		assert.Never(t, func() bool {
+			_, err := os.Stat(alive)
+			return err == nil
+		}, time.Until(start.Add(900*time.Millisecond)), 50*time.Millisecond)
+	})
+
+	t.Run("Cancellation", func(t *testing.T) {
+		cfg := newTestBackend(t, "exec://127.0.0.1:8080", EXEC)
+		cfg.Exec = ExecConfig{Command: "sh", Args: []string{"-c", "sleep 5"}}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := doExec(ctx, cfg)
+		assert.ErrorIs(t, err, ErrCommandFailed)
+		assert.ErrorContains(t, err, "killed on cancellation")
+		assert.NotContains(t, err.Error(), "killed after")
+	})
+
+	t.Run("Invalid config", func(t *testing.T) {
+		for _, cfg := range []ExecConfig{
+			{},
+			{Command: "true", Env: []string{"KEY"}},
+			{Command: "true", Env: []string{"=value"}},
+		} {
+			assert.Error(t, cfg.validate(), "%+v", cfg)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package health_monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	ErrNotServing = fmt.Errorf("service not serving")
+)
+
+// doGrpc calls grpc.health.v1.Health/Check on the backend.
+// Only the SERVING status is healthy. NOT_SERVING, UNKNOWN and errors,
+// including NotFound for unknown services, are unhealthy.
+func doGrpc(ctx context.Context, cfg *BackendConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	creds := insecure.NewCredentials()
+	if cfg.GRPC.TLS {
+		var tlsConfig *tls.Config
+		if cfg.TLS != nil {
+			tlsConfig = cfg.TLS.tlsConfig
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(cfg.Url.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{
+		Service: cfg.GRPC.Service,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("%w: %s", ErrNotServing, resp.GetStatus())
+	}
+	return nil
+}
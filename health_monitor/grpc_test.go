@@ -0,0 +1,86 @@
+package health_monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// newGrpcServer starts an in-process gRPC server implementing the health service.
+func newGrpcServer(t *testing.T, opts ...grpc.ServerOption) (*health.Server, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer(opts...)
+	healthServer := health.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+	return healthServer, lis.Addr().String()
+}
+
+func TestDoGrpc(t *testing.T) {
+	healthServer, addr := newGrpcServer(t)
+	healthServer.SetServingStatus("serving", grpc_health_v1.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("not-serving", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus("unknown", grpc_health_v1.HealthCheckResponse_UNKNOWN)
+
+	check := func(service string) error {
+		cfg := newTestBackend(t, "grpc://"+addr, GRPC)
+		cfg.GRPC.Service = service
+		return doGrpc(context.Background(), cfg)
+	}
+
+	// The overall health of the server is SERVING by default
+	assert.NoError(t, check(""))
+	assert.NoError(t, check("serving"))
+	assert.ErrorIs(t, check("not-serving"), ErrNotServing)
+	assert.ErrorIs(t, check("unknown"), ErrNotServing)
+	assert.Equal(t, codes.NotFound, status.Code(check("missing")))
+
+	healthServer.Shutdown()
+	assert.ErrorIs(t, check(""), ErrNotServing)
+
+	t.Run("Unreachable", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := lis.Addr().String()
+		require.NoError(t, lis.Close())
+
+		cfg := newTestBackend(t, "grpc://"+addr, GRPC)
+		start := time.Now()
+		assert.Equal(t, codes.Unavailable, status.Code(doGrpc(context.Background(), cfg)))
+		assert.Less(t, time.Since(start), cfg.Timeout)
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		ca := newTestCert(t, "ca", nil, time.Now().Add(time.Hour))
+		serverCert := newTestCert(t, "server", ca, time.Now().Add(time.Hour), "backend.internal")
+		_, addr := newGrpcServer(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{serverCert.tlsCert},
+		})))
+
+		cfg := newTestBackend(t, "grpc://"+addr, GRPC)
+		cfg.GRPC.TLS = true
+		cfg.TLS = &TLSConfig{CAFile: ca.certFile, ServerName: "backend.internal"}
+		require.NoError(t, cfg.TLS.load())
+		assert.NoError(t, doGrpc(context.Background(), cfg))
+
+		// Plaintext to a TLS server fails
+		cfg.GRPC.TLS = false
+		assert.Error(t, doGrpc(context.Background(), cfg))
+	})
+}
@@ -14,6 +14,8 @@ import (
 
 var (
 	ErrChannelNotEnabled = fmt.Errorf("channel not enabled")
+	ErrBodyMismatch      = fmt.Errorf("response body does not match")
+	ErrResponseMismatch  = fmt.Errorf("response does not match")
 )
 
 type HealthMonitor interface {
@@ -28,6 +30,7 @@ type HealthMonitor interface {
 	// also sent to this channel.
 	UnhealthyChan() (<-chan *HealthNoti, error)
 	// HealthyChan returns a channel that receives newly healthy backends.
+	// Healthy backends whose weight changes are also sent to this channel, see HealthNoti.Weight.
 	// If initial health is set to "Healthy", backends that are newly added are
 	// also sent to this channel.
 	HealthyChan() (<-chan *HealthNoti, error)
@@ -84,6 +87,10 @@ func NewHealthMonitor(ctx context.Context, opts ...Option) (HealthMonitor, error
 		return nil, err
 	}
 
+	if err := cfg.TLS.load(); err != nil {
+		return nil, err
+	}
+
 	if cfg.Timeout > cfg.Interval*2/3 {
 		cfg.logger.Warn().
 			Dur("timeout", cfg.Timeout).
@@ -164,12 +171,15 @@ func (h *healthMonitorImpl) Start() (err error) {
 				for _, backend := range h.backends {
 					go func(backend *Backend) {
 						defer wg.Done()
+						weight := backend.weight
 						if healthy, newly := h.healthcheck(backend); newly {
 							if healthy {
 								h.outputChans.sendHealthy(backend.toNoti())
 							} else {
 								h.outputChans.sendUnhealthy(backend.toNoti())
 							}
+						} else if healthy && backend.weight != weight {
+							h.outputChans.sendHealthy(backend.toNoti())
 						}
 					}(backend)
 				}
@@ -213,26 +223,8 @@ func (h *healthMonitorImpl) Add(beConfigs ...*BackendConfig) error {
 		if beConfigs[i].Url.String() == "" {
 			return fmt.Errorf("backend URL is required")
 		}
-
-		// set global defaults if not set
-		if beConfigs[i].Timeout > h.cfg.Interval*2/3 {
-			h.cfg.logger.Warn().
-				Str("backend", beConfigs[i].Name).
-				Dur("timeout", beConfigs[i].Timeout).
-				Dur("interval", h.cfg.Interval).
-				Msg("Connection timeout of backend is greater than 2/3 interval. Setting timeout to 2/3 interval.")
-			beConfigs[i].Timeout = h.cfg.Interval * 2 / 3
-		} else if beConfigs[i].Timeout == 0 {
-			beConfigs[i].Timeout = h.cfg.Timeout
-		}
-		if beConfigs[i].AcceptStatusCodes == nil {
-			beConfigs[i].AcceptStatusCodes = h.cfg.AcceptStatusCodes
-		}
-		if beConfigs[i].UnhealthyThreshold == 0 {
-			beConfigs[i].UnhealthyThreshold = h.cfg.UnhealthyThreshold
-		}
-		if beConfigs[i].HealthyThreshold == 0 {
-			beConfigs[i].HealthyThreshold = h.cfg.HealthyThreshold
+		if err := h.prepare(beConfigs[i]); err != nil {
+			return fmt.Errorf("backend %s: %w", beConfigs[i].Name, err)
 		}
 	}
 
@@ -251,10 +243,7 @@ func (h *healthMonitorImpl) Add(beConfigs ...*BackendConfig) error {
 		}
 
 		// Add BE state to the health monitor
-		be := &Backend{
-			Cfg:     beCfg,
-			healthy: h.cfg.HealthyInitially,
-		}
+		be := newBackend(beCfg, h.cfg.HealthyInitially)
 		h.backends[be.Cfg.Name] = be
 
 		if be.healthy {
@@ -267,6 +256,102 @@ func (h *healthMonitorImpl) Add(beConfigs ...*BackendConfig) error {
 	return nil
 }
 
+// prepare sets the global defaults of a backend configuration and validates it with the checker
+// of its protocol, or prepares its probes.
+func (h *healthMonitorImpl) prepare(cfg *BackendConfig) error {
+	// set global defaults if not set
+	if cfg.Timeout > h.cfg.Interval*2/3 {
+		h.cfg.logger.Warn().
+			Str("backend", cfg.Name).
+			Dur("timeout", cfg.Timeout).
+			Dur("interval", h.cfg.Interval).
+			Msg("Connection timeout of backend is greater than 2/3 interval. Setting timeout to 2/3 interval.")
+		cfg.Timeout = h.cfg.Interval * 2 / 3
+	} else if cfg.Timeout == 0 {
+		cfg.Timeout = h.cfg.Timeout
+	}
+	if cfg.AcceptStatusCodes == nil {
+		cfg.AcceptStatusCodes = h.cfg.AcceptStatusCodes
+	}
+	if cfg.UnhealthyThreshold == 0 {
+		cfg.UnhealthyThreshold = h.cfg.UnhealthyThreshold
+	}
+	if cfg.HealthyThreshold == 0 {
+		cfg.HealthyThreshold = h.cfg.HealthyThreshold
+	}
+	if cfg.TLS == nil {
+		tlsCfg := h.cfg.TLS
+		cfg.TLS = &tlsCfg
+	} else if cfg.TLS.tlsConfig == nil {
+		// Probes share the loaded configuration of their backend
+		cfg.TLS.inherit(&h.cfg.TLS)
+		if err := cfg.TLS.load(); err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.Probes) > 0 {
+		return h.prepareProbes(cfg)
+	}
+	checker, err := h.checker(cfg.Protocol)
+	if err != nil {
+		return err
+	}
+	if validator, ok := checker.(Validator); ok {
+		return validator.Validate(cfg)
+	}
+	return nil
+}
+
+// prepareProbes sets the defaults of the probes of a composite backend, inherited from the backend,
+// and validates them.
+func (h *healthMonitorImpl) prepareProbes(cfg *BackendConfig) error {
+	if _, err := cfg.requiredProbes(); err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(cfg.Probes))
+	for _, probe := range cfg.Probes {
+		if err := defaults.Set(probe); err != nil {
+			return err
+		}
+		if probe.Name == "" {
+			probe.Name = string(probe.Protocol)
+		}
+		if names[probe.Name] {
+			return fmt.Errorf("duplicate probe name %s", probe.Name)
+		}
+		names[probe.Name] = true
+		if len(probe.Probes) > 0 {
+			return fmt.Errorf("probe %s: probes cannot have probes", probe.Name)
+		}
+
+		if probe.Url.String() == "" {
+			probe.Url = cfg.Url
+		}
+		if probe.Timeout == 0 {
+			probe.Timeout = cfg.Timeout
+		}
+		if probe.AcceptStatusCodes == nil {
+			probe.AcceptStatusCodes = cfg.AcceptStatusCodes
+		}
+		if probe.UnhealthyThreshold == 0 {
+			probe.UnhealthyThreshold = cfg.UnhealthyThreshold
+		}
+		if probe.HealthyThreshold == 0 {
+			probe.HealthyThreshold = cfg.HealthyThreshold
+		}
+		if probe.TLS == nil {
+			probe.TLS = cfg.TLS
+		} else {
+			probe.TLS.inherit(cfg.TLS)
+		}
+		if err := h.prepare(probe); err != nil {
+			return fmt.Errorf("probe %s: %w", probe.Name, err)
+		}
+	}
+	return nil
+}
+
 func (h *healthMonitorImpl) Remove(backends ...string) {
 	h.backendsMtx.Lock()
 	defer h.backendsMtx.Unlock()
@@ -308,30 +393,18 @@ func (h *healthMonitorImpl) NextCheckAt() time.Time {
 }
 
 // healthcheck checks the health of the given backend.
-// Make a request to the backend according to the protocol: http, https, tcp, icmp
+// Make a request to the backend with the checker of its protocol, see Checker,
+// or check all its probes concurrently and combine their health, see BackendConfig.Probes.
 // If the backend is healthy, returns true.
 // Otherwise, returns false
 //
 // Assumes h.backendsMtx is locked.
 func (h *healthMonitorImpl) healthcheck(backend *Backend) (healthy bool, newly bool) {
-	var (
-		err    error
-		logger = h.cfg.logger.With().
-			Str("backend", backend.Cfg.Name).
-			Logger()
-	)
+	logger := h.cfg.logger.With().
+		Str("backend", backend.Cfg.Name).
+		Logger()
 
 	defer func() {
-		if r := recover(); r != nil {
-			var ok bool
-			if err, ok = r.(error); !ok {
-				err = fmt.Errorf("panic: %v", r)
-			}
-			logger.Err(err).Msg("Panic during health check")
-
-			// Mark this check as a failure
-			healthy, newly = backend.fail(h.cfg.UnhealthyThreshold)
-		}
 		if healthy && newly {
 			logger.Info().Msg("Backend entered healthy state")
 		} else if !healthy && newly {
@@ -339,46 +412,114 @@ func (h *healthMonitorImpl) healthcheck(backend *Backend) (healthy bool, newly b
 		}
 	}()
 
-	switch backend.Cfg.Protocol {
-	case HTTP, HTTPS:
-		var statusCode int
-		statusCode, err = doHttp(h.ctx, backend.Cfg.Url, h.cfg.Timeout)
-		if err == nil {
-			statusStr := fmt.Sprintf("%d", statusCode)
-			ok := false
-			for _, pattern := range h.cfg.AcceptStatusCodes {
-				if patternMatch(pattern, statusStr) {
-					ok = true
-					break
-				}
+	if len(backend.probes) == 0 {
+		return record(logger, backend, h.check(logger, backend.Cfg))
+	}
+
+	loggers := make([]zerolog.Logger, len(backend.probes))
+	results := make([]Result, len(backend.probes))
+	var wg sync.WaitGroup
+	wg.Add(len(backend.probes))
+	for i, probe := range backend.probes {
+		loggers[i] = logger.With().Str("probe", probe.Cfg.Name).Logger()
+		go func() {
+			defer wg.Done()
+			results[i] = h.check(loggers[i], probe.Cfg)
+		}()
+	}
+	wg.Wait()
+
+	passing := 0
+	for i, probe := range backend.probes {
+		if probeHealthy, probeNewly := record(loggers[i], probe, results[i]); probeHealthy {
+			passing++
+			if probeNewly {
+				loggers[i].Info().Msg("Probe entered healthy state")
 			}
+		} else if probeNewly {
+			loggers[i].Warn().Msg("Probe entered unhealthy state")
+		}
+	}
+	weight := backend.probes[0].weight
+	for _, probe := range backend.probes[1:] {
+		weight = min(weight, probe.weight)
+	}
+	backend.weight = weight
+
+	// Require is validated when the backend is added
+	required, _ := backend.Cfg.requiredProbes()
+	healthy = passing >= required
+	newly = healthy != backend.healthy
+	backend.healthy = healthy
+	logger.Debug().
+		Int("healthy_probes", passing).
+		Int("weight", weight).
+		Int("required_probes", required).
+		Msg("Health check of probes completed")
+
+	return healthy, newly
+}
+
+// check checks the health of a backend or a probe with the checker of its protocol.
+// A panic of the checker is a failed check.
+func (h *healthMonitorImpl) check(logger zerolog.Logger, cfg *BackendConfig) (result Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(error)
 			if !ok {
-				err = fmt.Errorf("unexpected status code: %d", statusCode)
+				err = fmt.Errorf("panic: %v", r)
 			}
+			logger.Err(err).Msg("Panic during health check")
+			result = Result{Err: err}
 		}
-	case TCP:
-		err = doTcp(backend.Cfg.Url, h.cfg.Timeout)
-	case ICMP:
-		err = doIcmp(backend.Cfg.Url, h.cfg.Timeout)
-	}
+	}()
 
-	// Calculate the fail/success streak
+	checker, err := h.checker(cfg.Protocol)
 	if err != nil {
-		healthy, newly = backend.fail(h.cfg.UnhealthyThreshold)
+		return Result{Err: err}
+	}
+	start := time.Now()
+	result = checker.Check(logger.WithContext(h.ctx), cfg)
+	if result.Latency == 0 {
+		result.Latency = time.Since(start)
+	}
+	return result
+}
+
+// record calculates the fail/success streak of a backend or a probe with the result of a check.
+func record(logger zerolog.Logger, backend *Backend, result Result) (healthy bool, newly bool) {
+	if result.Weight != nil && *result.Weight != backend.weight {
+		logger.Info().
+			Int("weight", *result.Weight).
+			Int("previous_weight", backend.weight).
+			Msg("Backend reported a new weight")
+		backend.weight = *result.Weight
+	}
+	if result.Err != nil {
+		healthy, newly = backend.fail(backend.Cfg.UnhealthyThreshold)
 		logger.Debug().
-			AnErr("error", err).
+			AnErr("error", result.Err).
+			Dur("latency", result.Latency).
 			Int("fail_streak", -backend.statusStreak).
 			Msg("Health check failed: did not receive response from backend")
 	} else {
-		healthy, newly = backend.success(h.cfg.HealthyThreshold)
+		healthy, newly = backend.success(backend.Cfg.HealthyThreshold)
 		logger.Debug().
+			Dur("latency", result.Latency).
 			Int("success_streak", backend.statusStreak).
 			Msg("Health check succeeded: received response from backend")
 	}
-
 	return healthy, newly
 }
 
+// checker returns the checker of the protocol, preferring the checkers of this health monitor.
+func (h *healthMonitorImpl) checker(protocol Protocol) (Checker, error) {
+	if checker, ok := h.cfg.checkers[protocol]; ok {
+		return checker, nil
+	}
+	return lookupChecker(protocol)
+}
+
 func newOutputChannels(enableHealthyChan bool, enableUnhealthyChan bool) outputChannels {
 	o := outputChannels{
 		enableHealthyChan:   enableHealthyChan,
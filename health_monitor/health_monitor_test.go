@@ -2,12 +2,18 @@ package health_monitor
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 )
@@ -368,3 +374,216 @@ func createICMPBackend(_ *testing.T, address string) *BackendConfig {
 		Protocol: ICMP,
 	}
 }
+
+// TestHealthMonitorBackendOverrides tests that the timeout, thresholds and accepted status codes
+// of a backend override the global ones, which are the defaults of backends that do not set them.
+func TestHealthMonitorBackendOverrides(t *testing.T) {
+	var timeouts sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	hm, err := NewHealthMonitor(context.Background(),
+		WithCheckInterval(time.Second),
+		WithTimeout(500*time.Millisecond),
+		WithUnhealthyThreshold(3),
+		WithHealthyThreshold(3),
+		WithAcceptStatusCodes("2.+"),
+		WithChecker("test-failing", CheckerFunc(func(_ context.Context, cfg *BackendConfig) Result {
+			timeouts.Store(cfg.Name, cfg.Timeout)
+			return Result{Err: fmt.Errorf("failing")}
+		})),
+	)
+	require.NoError(t, err)
+	impl := hm.(*healthMonitorImpl)
+
+	overridden := newTestBackend(t, "failing://overridden", "test-failing")
+	overridden.Name = "overridden"
+	overridden.Timeout = 200 * time.Millisecond
+	overridden.UnhealthyThreshold = 1
+	global := newTestBackend(t, "failing://global", "test-failing")
+	global.Name = "global"
+	global.Timeout = 0
+	accepted := newTestBackend(t, server.URL, HTTP)
+	accepted.Name = "accepted"
+	accepted.AcceptStatusCodes = []string{"503"}
+	accepted.HealthyThreshold = 1
+	rejected := newTestBackend(t, server.URL, HTTP)
+	rejected.Name = "rejected"
+	rejected.AcceptStatusCodes = nil
+	rejected.UnhealthyThreshold = 1
+	require.NoError(t, hm.Add(overridden, global, accepted, rejected))
+
+	healthy, newly := impl.healthcheck(impl.backends["overridden"])
+	assert.False(t, healthy)
+	assert.True(t, newly)
+	timeout, _ := timeouts.Load("overridden")
+	assert.Equal(t, 200*time.Millisecond, timeout)
+
+	healthy, newly = impl.healthcheck(impl.backends["global"])
+	assert.True(t, healthy)
+	assert.False(t, newly)
+	timeout, _ = timeouts.Load("global")
+	assert.Equal(t, 500*time.Millisecond, timeout)
+
+	healthy, newly = impl.healthcheck(impl.backends["accepted"])
+	assert.True(t, healthy)
+	assert.True(t, newly)
+
+	healthy, newly = impl.healthcheck(impl.backends["rejected"])
+	assert.False(t, healthy)
+	assert.True(t, newly)
+}
+
+// newProbe returns a probe of the "test-switch" protocol, healthy while the returned switch is on.
+func newProbe(t *testing.T, name string, on bool) (*BackendConfig, *atomic.Bool) {
+	probe := newTestBackend(t, "switch://"+name, "test-switch")
+	probe.Name = name
+	probe.Timeout = 0
+	probe.AcceptStatusCodes = nil
+	var healthy atomic.Bool
+	healthy.Store(on)
+	probe.Options = map[string]interface{}{"switch": &healthy}
+	return probe, &healthy
+}
+
+func TestHealthMonitorProbes(t *testing.T) {
+	newMonitor := func(t *testing.T) *healthMonitorImpl {
+		hm, err := NewHealthMonitor(context.Background(),
+			WithUnhealthyThreshold(1),
+			WithHealthyThreshold(1),
+			WithChecker("test-switch", CheckerFunc(func(_ context.Context, cfg *BackendConfig) Result {
+				if !cfg.Options["switch"].(*atomic.Bool).Load() {
+					return Result{Err: fmt.Errorf("switched off")}
+				}
+				return Result{}
+			})),
+		)
+		require.NoError(t, err)
+		return hm.(*healthMonitorImpl)
+	}
+	newComposite := func(t *testing.T, require string, probes ...*BackendConfig) *BackendConfig {
+		cfg := newTestBackend(t, "http://127.0.0.1:8080/health", HTTP)
+		cfg.Name = "composite"
+		cfg.Require = require
+		cfg.Probes = probes
+		return cfg
+	}
+
+	t.Run("Require", func(t *testing.T) {
+		for _, tt := range []struct {
+			require string
+			healthy bool
+		}{
+			{require: "all", healthy: false},
+			{require: "ALL", healthy: false},
+			{require: "any", healthy: true},
+			{require: "2", healthy: true},
+			{require: "3", healthy: false},
+		} {
+			t.Run(tt.require, func(t *testing.T) {
+				hm := newMonitor(t)
+				a, _ := newProbe(t, "a", true)
+				b, _ := newProbe(t, "b", false)
+				c, _ := newProbe(t, "c", true)
+				require.NoError(t, hm.Add(newComposite(t, tt.require, a, b, c)))
+
+				healthy, newly := hm.healthcheck(hm.backends["composite"])
+				assert.Equal(t, tt.healthy, healthy)
+				// Backends are initially healthy
+				assert.Equal(t, !tt.healthy, newly)
+				assert.Equal(t, tt.healthy, hm.IsHealthy("composite"))
+				assert.Equal(t, map[string]bool{"a": true, "b": false, "c": true},
+					hm.backends["composite"].toNoti().Probes)
+			})
+		}
+	})
+
+	t.Run("Streaks", func(t *testing.T) {
+		hm := newMonitor(t)
+		http, httpOn := newProbe(t, "http", true)
+		http.UnhealthyThreshold = 2
+		tcp, _ := newProbe(t, "tcp", true)
+		require.NoError(t, hm.Add(newComposite(t, "all", http, tcp)))
+		backend := hm.backends["composite"]
+
+		healthy, newly := hm.healthcheck(backend)
+		assert.True(t, healthy)
+		assert.False(t, newly)
+
+		// The http probe only becomes unhealthy after its own threshold
+		httpOn.Store(false)
+		healthy, newly = hm.healthcheck(backend)
+		assert.True(t, healthy)
+		assert.False(t, newly)
+		healthy, newly = hm.healthcheck(backend)
+		assert.False(t, healthy)
+		assert.True(t, newly)
+		assert.Equal(t, -2, backend.probes[0].statusStreak)
+		assert.Equal(t, 3, backend.probes[1].statusStreak)
+
+		healthy, newly = hm.healthcheck(backend)
+		assert.False(t, healthy)
+		assert.False(t, newly)
+
+		httpOn.Store(true)
+		healthy, newly = hm.healthcheck(backend)
+		assert.True(t, healthy)
+		assert.True(t, newly)
+	})
+
+	t.Run("Inheritance", func(t *testing.T) {
+		hm := newMonitor(t)
+		probe, _ := newProbe(t, "", true)
+		probe.Url = url.URL{}
+		tlsProbe, _ := newProbe(t, "tls", true)
+		tlsProbe.TLS = &TLSConfig{ServerName: "probe.internal"}
+		cfg := newComposite(t, "", probe, tlsProbe)
+		cfg.Timeout = 300 * time.Millisecond
+		cfg.UnhealthyThreshold = 5
+		cfg.TLS = &TLSConfig{ServerName: "backend.internal", MinVersion: "1.3"}
+		require.NoError(t, hm.Add(cfg))
+
+		assert.Equal(t, "all", cfg.Require)
+		assert.Equal(t, "test-switch", probe.Name)
+		assert.Equal(t, cfg.Url, probe.Url)
+		assert.Equal(t, 300*time.Millisecond, probe.Timeout)
+		assert.Equal(t, []string{"2.+"}, probe.AcceptStatusCodes)
+		assert.Equal(t, 5, probe.UnhealthyThreshold)
+		assert.Equal(t, 1, probe.HealthyThreshold)
+		assert.Same(t, cfg.TLS, probe.TLS)
+		assert.Equal(t, "probe.internal", tlsProbe.TLS.tlsConfig.ServerName)
+		assert.Equal(t, uint16(tls.VersionTLS13), tlsProbe.TLS.tlsConfig.MinVersion)
+	})
+
+	t.Run("Invalid config", func(t *testing.T) {
+		hm := newMonitor(t)
+		for name, newCfg := range map[string]func() *BackendConfig{
+			"require": func() *BackendConfig {
+				a, _ := newProbe(t, "a", true)
+				return newComposite(t, "2", a)
+			},
+			"duplicate probe name": func() *BackendConfig {
+				a, _ := newProbe(t, "a", true)
+				b, _ := newProbe(t, "a", true)
+				return newComposite(t, "all", a, b)
+			},
+			"probes cannot have probes": func() *BackendConfig {
+				a, _ := newProbe(t, "a", true)
+				b, _ := newProbe(t, "b", true)
+				a.Probes = []*BackendConfig{b}
+				return newComposite(t, "all", a)
+			},
+			"probe tcp: invalid tcp expect": func() *BackendConfig {
+				probe := newTestBackend(t, "tcp://127.0.0.1:9000", TCP)
+				probe.Name = ""
+				probe.TCP.Expect = "("
+				return newComposite(t, "all", probe)
+			},
+		} {
+			assert.ErrorContains(t, hm.Add(newCfg()), name)
+		}
+		assert.Equal(t, 0, hm.Size())
+	})
+}
@@ -0,0 +1,224 @@
+package health_monitor
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+)
+
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+var (
+	ErrPacketLoss = fmt.Errorf("packet loss too high")
+)
+
+type ICMPConfig struct {
+	// Count is the number of echo requests sent per health check. Default is 1.
+	// The echoes are sent one after another, within the timeout of the backend.
+	Count int `mapstructure:"count" default:"1"`
+	// MaxLoss is the maximum percentage of lost echoes for the backend to be healthy. Default is 0.
+	MaxLoss float64 `mapstructure:"max_loss"`
+}
+
+func (c *ICMPConfig) validate() error {
+	if c.Count < 1 {
+		return fmt.Errorf("icmp count must be positive")
+	}
+	if !(c.MaxLoss >= 0 && c.MaxLoss <= 100) {
+		return fmt.Errorf("icmp max_loss must be between 0 and 100")
+	}
+	return nil
+}
+
+// icmpStats are the results of the echoes sent by a health check.
+type icmpStats struct {
+	sent     int
+	received int
+	minRTT   time.Duration
+	avgRTT   time.Duration
+	maxRTT   time.Duration
+}
+
+// loss returns the percentage of lost echoes.
+func (s icmpStats) loss() float64 {
+	if s.sent == 0 {
+		return 0
+	}
+	return float64(s.sent-s.received) * 100 / float64(s.sent)
+}
+
+func (s *icmpStats) addRTT(rtt time.Duration) {
+	if s.received == 0 || rtt < s.minRTT {
+		s.minRTT = rtt
+	}
+	if rtt > s.maxRTT {
+		s.maxRTT = rtt
+	}
+	s.avgRTT = (s.avgRTT*time.Duration(s.received) + rtt) / time.Duration(s.received+1)
+	s.received++
+}
+
+// icmpConn is an ICMP socket, either an unprivileged datagram socket or a raw socket.
+type icmpConn struct {
+	*icmp.PacketConn
+	v6 bool
+	// privileged is true for raw sockets, which receive all ICMP messages of the host.
+	// For datagram sockets, the kernel sets the echo ID and only delivers replies to our echoes.
+	privileged bool
+}
+
+// listenICMP opens an unprivileged datagram ICMP socket, allowed by net.ipv4.ping_group_range on Linux,
+// and falls back to a raw socket, which requires CAP_NET_RAW.
+func listenICMP(v6 bool) (*icmpConn, error) {
+	network, rawNetwork, address := "udp4", "ip4:icmp", "0.0.0.0"
+	if v6 {
+		network, rawNetwork, address = "udp6", "ip6:ipv6-icmp", "::"
+	}
+	if conn, err := icmp.ListenPacket(network, address); err == nil {
+		return &icmpConn{PacketConn: conn, v6: v6}, nil
+	}
+	conn, err := icmp.ListenPacket(rawNetwork, address)
+	if err != nil {
+		return nil, err
+	}
+	return &icmpConn{PacketConn: conn, v6: v6, privileged: true}, nil
+}
+
+// doIcmp sends cfg.ICMP.Count echo requests to the backend and returns the round-trip statistics.
+// It returns an error if the loss is higher than cfg.ICMP.MaxLoss.
+func doIcmp(ctx context.Context, cfg *BackendConfig) (icmpStats, error) {
+	var stats icmpStats
+	deadline := time.Now().Add(cfg.Timeout)
+
+	ip, err := resolveIP(ctx, cfg.Url.Hostname())
+	if err != nil {
+		return stats, err
+	}
+	conn, err := listenICMP(ip.Is6())
+	if err != nil {
+		return stats, err
+	}
+	defer conn.Close()
+
+	var dst net.Addr = &net.UDPAddr{IP: ip.AsSlice()}
+	if conn.privileged {
+		dst = &net.IPAddr{IP: ip.AsSlice()}
+	}
+
+	count := cfg.ICMP.Count
+	if count < 1 {
+		count = 1
+	}
+	id := rand.Intn(0x10000)
+	// Each echo gets an equal share of the timeout
+	echoTimeout := time.Until(deadline) / time.Duration(count)
+	for seq := 0; seq < count; seq++ {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		stats.sent++
+		rtt, err := conn.echo(dst, ip, id, seq, time.Now().Add(echoTimeout))
+		if err != nil {
+			continue
+		}
+		stats.addRTT(rtt)
+	}
+
+	if stats.loss() > cfg.ICMP.MaxLoss {
+		return stats, fmt.Errorf("%w: %.0f%% of %d echoes lost", ErrPacketLoss, stats.loss(), stats.sent)
+	}
+	return stats, nil
+}
+
+// resolveIP resolves the host to an IP address, preferring IPv4.
+func resolveIP(ctx context.Context, host string) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.Unmap(), nil
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	for _, ip := range ips {
+		if ip.Unmap().Is4() {
+			return ip.Unmap(), nil
+		}
+	}
+	if len(ips) == 0 {
+		return netip.Addr{}, fmt.Errorf("no addresses found for %s", host)
+	}
+	return ips[0], nil
+}
+
+// echo sends an echo request and waits for its reply until the deadline. It returns the round-trip time.
+func (c *icmpConn) echo(dst net.Addr, ip netip.Addr, id int, seq int, deadline time.Time) (time.Duration, error) {
+	var (
+		typ       icmp.Type = ipv4.ICMPTypeEcho
+		replyType icmp.Type = ipv4.ICMPTypeEchoReply
+		proto               = protocolICMP
+	)
+	if c.v6 {
+		typ, replyType, proto = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply, protocolICMPv6
+	}
+	msg := icmp.Message{
+		Type: typ,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("maglev-go health check")},
+	}
+	// The kernel computes the ICMPv6 checksum
+	data, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := c.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if _, err := c.WriteTo(data, dst); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		rtt := time.Since(start)
+
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		echo, ok := reply.Body.(*icmp.Echo)
+		if !ok || echo.Seq != seq {
+			continue
+		}
+		// Raw sockets receive replies to other processes' echoes too
+		if c.privileged && (echo.ID != id || !peerIs(peer, ip)) {
+			continue
+		}
+		return rtt, nil
+	}
+}
+
+func peerIs(peer net.Addr, ip netip.Addr) bool {
+	var peerIP net.IP
+	switch peer := peer.(type) {
+	case *net.IPAddr:
+		peerIP = peer.IP
+	case *net.UDPAddr:
+		peerIP = peer.IP
+	}
+	addr, ok := netip.AddrFromSlice(peerIP)
+	return ok && addr.Unmap() == ip
+}
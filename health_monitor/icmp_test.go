@@ -0,0 +1,73 @@
+package health_monitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoIcmp(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "[::1]"} {
+		t.Run(host, func(t *testing.T) {
+			cfg := newTestBackend(t, "icmp://"+host, ICMP)
+			ip, err := resolveIP(context.Background(), cfg.Url.Hostname())
+			require.NoError(t, err)
+			conn, err := listenICMP(ip.Is6())
+			if err != nil {
+				t.Skipf("ICMP sockets are not permitted: %v", err)
+			}
+			conn.Close()
+
+			cfg.ICMP.Count = 3
+			stats, err := doIcmp(context.Background(), cfg)
+			require.NoError(t, err)
+			assert.Equal(t, 3, stats.sent)
+			assert.Equal(t, 3, stats.received)
+			assert.Equal(t, 0.0, stats.loss())
+			assert.Greater(t, stats.minRTT, time.Duration(0))
+			assert.LessOrEqual(t, stats.minRTT, stats.avgRTT)
+			assert.LessOrEqual(t, stats.avgRTT, stats.maxRTT)
+		})
+	}
+
+	t.Run("Unreachable", func(t *testing.T) {
+		conn, err := listenICMP(false)
+		if err != nil {
+			t.Skipf("ICMP sockets are not permitted: %v", err)
+		}
+		conn.Close()
+
+		// TEST-NET-3 is reserved for documentation
+		cfg := newTestBackend(t, "icmp://203.0.113.7", ICMP)
+		cfg.Timeout = 300 * time.Millisecond
+		cfg.ICMP.Count = 2
+		cfg.ICMP.MaxLoss = 50
+
+		start := time.Now()
+		stats, err := doIcmp(context.Background(), cfg)
+		assert.ErrorIs(t, err, ErrPacketLoss)
+		assert.Equal(t, 2, stats.sent)
+		assert.Equal(t, 0, stats.received)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+func TestIcmpStats(t *testing.T) {
+	var stats icmpStats
+	stats.sent = 4
+	for _, rtt := range []time.Duration{3, 1, 2} {
+		stats.addRTT(rtt * time.Millisecond)
+	}
+	assert.Equal(t, 3, stats.received)
+	assert.Equal(t, 25.0, stats.loss())
+	assert.Equal(t, time.Millisecond, stats.minRTT)
+	assert.Equal(t, 2*time.Millisecond, stats.avgRTT)
+	assert.Equal(t, 3*time.Millisecond, stats.maxRTT)
+
+	for _, cfg := range []ICMPConfig{{Count: 0}, {Count: 1, MaxLoss: -1}, {Count: 1, MaxLoss: 101}} {
+		assert.Error(t, cfg.validate(), "%+v", cfg)
+	}
+}
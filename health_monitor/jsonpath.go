@@ -0,0 +1,136 @@
+package health_monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidJSONAssertion = fmt.Errorf("invalid JSON assertion")
+	ErrJSONAssertionFailed  = fmt.Errorf("JSON assertion failed")
+)
+
+// jsonAssertion is an assertion on a JSON document, e.g. `$.status == "UP"`.
+//
+// The path starts at the root `$` and is followed by fields `.name` or `["name"]` and array indexes `[0]`.
+// The path may be compared to a JSON value with `==` or `!=`. Without a comparison,
+// the assertion holds if the path exists.
+type jsonAssertion struct {
+	expr string
+	// path is a list of field names (string) and array indexes (int).
+	path []interface{}
+	op   string
+	// value is the decoded right-hand side of the comparison.
+	value interface{}
+}
+
+func parseJSONAssertion(expr string) (*jsonAssertion, error) {
+	a := &jsonAssertion{expr: expr}
+	pathExpr := expr
+	// The first operator separates the path from the value, which may contain operators itself
+	if i := strings.IndexAny(expr, "=!"); i >= 0 && i+1 < len(expr) && expr[i+1] == '=' {
+		pathExpr = expr[:i]
+		a.op = expr[i : i+2]
+		if err := json.Unmarshal([]byte(strings.TrimSpace(expr[i+2:])), &a.value); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalidJSONAssertion, expr, err)
+		}
+	}
+
+	path, err := parseJSONPath(strings.TrimSpace(pathExpr))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrInvalidJSONAssertion, expr, err)
+	}
+	a.path = path
+	return a, nil
+}
+
+func parseJSONPath(s string) ([]interface{}, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("path must start with $")
+	}
+	s = s[1:]
+
+	var path []interface{}
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			end := strings.IndexAny(s[1:], ".[")
+			if end < 0 {
+				end = len(s) - 1
+			}
+			name := s[1 : end+1]
+			if name == "" || strings.ContainsAny(name, " \t=!") {
+				return nil, fmt.Errorf("invalid field name %q", name)
+			}
+			path = append(path, name)
+			s = s[end+1:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated [")
+			}
+			inner := s[1:end]
+			if strings.HasPrefix(inner, `"`) {
+				name, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid field name %s", inner)
+				}
+				path = append(path, name)
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid array index %s", inner)
+				}
+				path = append(path, index)
+			}
+			s = s[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", s[0])
+		}
+	}
+	return path, nil
+}
+
+// eval returns nil if the assertion holds for the given JSON document.
+func (a *jsonAssertion) eval(data []byte) error {
+	var node interface{}
+	if err := json.Unmarshal(data, &node); err != nil {
+		return fmt.Errorf("%w: %s: invalid JSON: %v", ErrJSONAssertionFailed, a.expr, err)
+	}
+
+	for _, step := range a.path {
+		var ok bool
+		switch step := step.(type) {
+		case string:
+			var obj map[string]interface{}
+			if obj, ok = node.(map[string]interface{}); ok {
+				node, ok = obj[step]
+			}
+		case int:
+			var arr []interface{}
+			if arr, ok = node.([]interface{}); ok && step < len(arr) {
+				node = arr[step]
+			} else {
+				ok = false
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s: path not found", ErrJSONAssertionFailed, a.expr)
+		}
+	}
+
+	switch a.op {
+	case "==":
+		if !reflect.DeepEqual(node, a.value) {
+			return fmt.Errorf("%w: %s: got %v", ErrJSONAssertionFailed, a.expr, node)
+		}
+	case "!=":
+		if reflect.DeepEqual(node, a.value) {
+			return fmt.Errorf("%w: %s: got %v", ErrJSONAssertionFailed, a.expr, node)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package health_monitor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONAssertion(t *testing.T) {
+	doc := []byte(`{"status": "UP", "a.b": {"c": [1, "x!=y", null]}, "ok": true}`)
+
+	tests := []struct {
+		expr  string
+		holds bool
+	}{
+		{`$.status == "UP"`, true},
+		{`$.status=="UP"`, true},
+		{`$.status != "UP"`, false},
+		{`$.status == "DOWN"`, false},
+		{`$["a.b"].c[0] == 1`, true},
+		{`$["a.b"].c[0] == 1.0`, true},
+		{`$["a.b"].c[1] == "x!=y"`, true},
+		{`$["a.b"].c[2] == null`, true},
+		{`$["a.b"].c[3]`, false},
+		{`$.ok`, true},
+		{`$.ok == true`, true},
+		{`$.missing`, false},
+		{`$.status.nested`, false},
+		{`$`, true},
+	}
+	for _, tt := range tests {
+		a, err := parseJSONAssertion(tt.expr)
+		require.NoError(t, err, tt.expr)
+		if tt.holds {
+			assert.NoError(t, a.eval(doc), tt.expr)
+		} else {
+			assert.ErrorIs(t, a.eval(doc), ErrJSONAssertionFailed, tt.expr)
+		}
+	}
+
+	t.Run("Invalid assertions", func(t *testing.T) {
+		for _, expr := range []string{
+			`status == "UP"`,
+			`$.status = "UP"`,
+			`$.status == UP`,
+			`$..status`,
+			`$.checks[-1]`,
+			`$.checks[0`,
+			`$[unquoted]`,
+		} {
+			_, err := parseJSONAssertion(expr)
+			assert.ErrorIs(t, err, ErrInvalidJSONAssertion, expr)
+		}
+	})
+
+	t.Run("Invalid JSON document", func(t *testing.T) {
+		a, err := parseJSONAssertion(`$.status`)
+		require.NoError(t, err)
+		assert.ErrorIs(t, a.eval([]byte("not json")), ErrJSONAssertionFailed)
+	})
+}
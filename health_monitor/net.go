@@ -1,49 +1,215 @@
 package health_monitor
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"fmt"
+	"github.com/rs/zerolog"
+	"io"
 	"net"
 	"net/http"
-	"net/url"
-	"os/exec"
+	"regexp"
+	"strings"
 	"time"
 )
 
-func doHttp(ctx context.Context, url url.URL, timeout time.Duration) (int, error) {
+// maxBodySize is the maximum size of a response body read for body checks.
+const maxBodySize = 1 << 20
+
+func doHttp(ctx context.Context, cfg *BackendConfig) error {
 	client := http.Client{
-		Timeout: timeout,
+		Timeout: cfg.Timeout,
+	}
+	if cfg.Protocol == HTTPS && cfg.TLS != nil {
+		client.Transport = &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   cfg.TLS.tlsConfig,
+			DisableKeepAlives: true,
+		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	var body io.Reader
+	if cfg.HTTP.Body != "" {
+		body = strings.NewReader(cfg.HTTP.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, cfg.HTTP.Method, cfg.Url.String(), body)
 	if err != nil {
-		return 0, err
+		return err
 	}
+	for name, value := range cfg.HTTP.Headers {
+		// Go ignores the Host header in req.Header
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	if cfg.HTTP.Host != "" {
+		req.Host = cfg.HTTP.Host
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	return resp.StatusCode, nil
-}
+	if cfg.TLS != nil {
+		if cert := cfg.TLS.expiringCertificate(resp.TLS); cert != nil {
+			zerolog.Ctx(ctx).Warn().
+				Str("subject", cert.Subject.String()).
+				Time("not_after", cert.NotAfter).
+				Msg("Backend certificate expires soon")
+		}
+	}
 
-func doTcp(url url.URL, timeout time.Duration) error {
-	address := fmt.Sprintf("%s:%s", url.Hostname(), url.Port())
-	conn, err := net.DialTimeout("tcp", address, timeout)
+	statusStr := fmt.Sprintf("%d", resp.StatusCode)
+	ok := false
+	for _, pattern := range cfg.AcceptStatusCodes {
+		if patternMatch(pattern, statusStr) {
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if cfg.HTTP.ExpectBody == "" && len(cfg.HTTP.ExpectJSON) == 0 {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
 	if err != nil {
 		return err
 	}
-	conn.Close()
+	if cfg.HTTP.ExpectBody != "" {
+		re, err := regexp.Compile(cfg.HTTP.ExpectBody)
+		if err != nil {
+			return err
+		}
+		if !re.Match(data) {
+			return fmt.Errorf("%w: %s", ErrBodyMismatch, cfg.HTTP.ExpectBody)
+		}
+	}
+	for _, expr := range cfg.HTTP.ExpectJSON {
+		assertion, err := parseJSONAssertion(expr)
+		if err != nil {
+			return err
+		}
+		if err := assertion.eval(data); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func doIcmp(url url.URL, timeout time.Duration) error {
-	host := url.Hostname()
-	// Execute the 'ping' command
-	return exec.Command(
-		"ping",
-		"-c", "1", "-W", fmt.Sprintf("%.0f", timeout.Seconds()),
-		host,
-	).Run()
+// maxReceiveSize is the maximum size of the data received by a tcp check.
+const maxReceiveSize = 64 * 1024
+
+// doTcp connects to the backend, sends cfg.TCP.Send and reads until cfg.TCP.Expect matches the received data.
+func doTcp(ctx context.Context, cfg *BackendConfig) error {
+	deadline := time.Now().Add(cfg.Timeout)
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(cfg.Url.Hostname(), cfg.Url.Port()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	if cfg.TCP.Send != "" {
+		if _, err := io.WriteString(conn, cfg.TCP.Send); err != nil {
+			return err
+		}
+	}
+	if cfg.TCP.Expect == "" {
+		return nil
+	}
+	re, err := regexp.Compile(cfg.TCP.Expect)
+	if err != nil {
+		return err
+	}
+
+	if readDeadline := time.Now().Add(cfg.TCP.ReadTimeout); cfg.TCP.ReadTimeout > 0 && readDeadline.Before(deadline) {
+		if err := conn.SetReadDeadline(readDeadline); err != nil {
+			return err
+		}
+	}
+	// Responses may arrive in several segments, read until the pattern matches
+	received := make([]byte, 0, 512)
+	buf := make([]byte, 4096)
+	for len(received) < maxReceiveSize {
+		n, err := conn.Read(buf)
+		received = append(received, buf[:n]...)
+		if re.Match(received) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v, received %q", ErrResponseMismatch, cfg.TCP.Expect, err, truncate(received, 64))
+		}
+	}
+	return fmt.Errorf("%w: %s: received %q", ErrResponseMismatch, cfg.TCP.Expect, truncate(received, 64))
+}
+
+// truncate returns the first n bytes of data.
+func truncate(data []byte, n int) []byte {
+	if len(data) > n {
+		return data[:n]
+	}
+	return data
+}
+
+// maxDatagramSize is the maximum size of a UDP response.
+const maxDatagramSize = 64 * 1024
+
+// doUdp sends the configured datagram to the backend and validates the response.
+// On a connected UDP socket, an ICMP port unreachable error is reported by the read.
+func doUdp(ctx context.Context, cfg *BackendConfig) error {
+	payload, err := cfg.UDP.payload()
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(cfg.Url.Hostname(), cfg.Url.Port()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(cfg.Timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return err
+	}
+	resp := make([]byte, maxDatagramSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return err
+	}
+	resp = resp[:n]
+
+	if cfg.UDP.ExpectHex != "" {
+		prefix, err := hex.DecodeString(cfg.UDP.ExpectHex)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(resp, prefix) {
+			return fmt.Errorf("%w: expected prefix %s, got %x", ErrResponseMismatch, cfg.UDP.ExpectHex, resp)
+		}
+	}
+	if cfg.UDP.Expect != "" {
+		re, err := regexp.Compile(cfg.UDP.Expect)
+		if err != nil {
+			return err
+		}
+		if !re.Match(resp) {
+			return fmt.Errorf("%w: %s", ErrResponseMismatch, cfg.UDP.Expect)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,410 @@
+package health_monitor
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/creasty/defaults"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestBackend returns the config of a backend at the given URL, with defaults set
+// as if it was added to a health monitor.
+func newTestBackend(t *testing.T, rawUrl string, protocol Protocol) *BackendConfig {
+	u, err := url.Parse(rawUrl)
+	require.NoError(t, err)
+	cfg := &BackendConfig{
+		Name:              "backend",
+		Url:               *u,
+		Protocol:          protocol,
+		Timeout:           time.Second,
+		AcceptStatusCodes: []string{"2.+"},
+	}
+	require.NoError(t, defaults.Set(cfg))
+	return cfg
+}
+
+func TestDoHttp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"status": "UP", "checks": [{"name": "db", "healthy": true}], "uptime": 42}`)
+		case "/echo":
+			if r.Header.Get("Authorization") != "Bearer token" || r.Host != "svc.internal" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			_, _ = io.WriteString(w, r.Method+" "+string(body))
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = io.WriteString(w, `{"status": "DOWN"}`)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		cfg     HTTPConfig
+		accept  []string
+		wantErr error
+		// errContains is checked for errors without a sentinel.
+		errContains string
+	}{
+		{
+			name: "Default GET",
+			path: "/health",
+		},
+		{
+			name:        "Unexpected status code",
+			path:        "/down",
+			errContains: "unexpected status code: 503",
+		},
+		{
+			name:   "Accepted status code",
+			path:   "/down",
+			accept: []string{"503"},
+			cfg:    HTTPConfig{ExpectJSON: []string{`$.status == "DOWN"`}},
+		},
+		{
+			name: "Method, headers, host and body",
+			path: "/echo",
+			cfg: HTTPConfig{
+				Method:     http.MethodPost,
+				Headers:    map[string]string{"authorization": "Bearer token"},
+				Host:       "svc.internal",
+				Body:       "ping",
+				ExpectBody: "^POST ping$",
+			},
+		},
+		{
+			name: "Host header",
+			path: "/echo",
+			cfg: HTTPConfig{
+				Headers:    map[string]string{"Authorization": "Bearer token", "host": "svc.internal"},
+				ExpectBody: "GET",
+			},
+		},
+		{
+			name:        "Missing headers",
+			path:        "/echo",
+			errContains: "unexpected status code: 401",
+		},
+		{
+			name: "HEAD",
+			path: "/health",
+			cfg:  HTTPConfig{Method: http.MethodHead},
+		},
+		{
+			name: "Body regex",
+			path: "/health",
+			cfg:  HTTPConfig{ExpectBody: `"status":\s*"UP"`},
+		},
+		{
+			name:    "Body regex mismatch",
+			path:    "/health",
+			cfg:     HTTPConfig{ExpectBody: `"status":\s*"DOWN"`},
+			wantErr: ErrBodyMismatch,
+		},
+		{
+			name: "JSON assertions",
+			path: "/health",
+			cfg: HTTPConfig{ExpectJSON: []string{
+				`$.status == "UP"`,
+				`$.checks[0].healthy == true`,
+				`$["uptime"] != 0`,
+				`$.checks[0].name`,
+			}},
+		},
+		{
+			name:    "JSON assertion failure",
+			path:    "/health",
+			cfg:     HTTPConfig{ExpectJSON: []string{`$.status == "UP"`, `$.checks[1].healthy == true`}},
+			wantErr: ErrJSONAssertionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestBackend(t, server.URL+tt.path, HTTP)
+			cfg.HTTP = tt.cfg
+			require.NoError(t, defaults.Set(cfg))
+			if tt.accept != nil {
+				cfg.AcceptStatusCodes = tt.accept
+			}
+
+			err := doHttp(context.Background(), cfg)
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.errContains != "":
+				assert.ErrorContains(t, err, tt.errContains)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAddValidatesHTTPConfig(t *testing.T) {
+	hm, err := NewHealthMonitor(context.Background())
+	require.NoError(t, err)
+
+	cfg := newTestBackend(t, "http://localhost/health", HTTP)
+	cfg.HTTP.ExpectBody = "("
+	assert.Error(t, hm.Add(cfg))
+
+	cfg = newTestBackend(t, "http://localhost/health", HTTP)
+	cfg.HTTP.ExpectJSON = []string{"status == 1"}
+	assert.ErrorIs(t, hm.Add(cfg), ErrInvalidJSONAssertion)
+
+	for _, expect := range []HTTPConfig{{ExpectBody: "UP"}, {ExpectJSON: []string{"$.status"}}} {
+		cfg = newTestBackend(t, "http://localhost/health", HTTP)
+		cfg.HTTP = expect
+		cfg.HTTP.Method = "head"
+		assert.ErrorContains(t, hm.Add(cfg), "method HEAD")
+	}
+	assert.Equal(t, 0, hm.Size())
+}
+
+// newUdpServer starts a UDP server answering each datagram with the result of handle.
+// If handle returns nil, the datagram is not answered.
+func newUdpServer(t *testing.T, handle func(req []byte) []byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := handle(buf[:n]); resp != nil {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDoUdp(t *testing.T) {
+	echo := newUdpServer(t, func(req []byte) []byte {
+		return append([]byte("echo: "), req...)
+	})
+	silent := newUdpServer(t, func([]byte) []byte { return nil })
+
+	// A closed port answers with ICMP port unreachable
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := conn.LocalAddr().String()
+	require.NoError(t, conn.Close())
+
+	tests := []struct {
+		name    string
+		addr    string
+		cfg     UDPConfig
+		wantErr error
+		// errContains is checked for errors without a sentinel.
+		errContains string
+	}{
+		{
+			name: "Any response",
+			addr: echo,
+			cfg:  UDPConfig{Payload: "PING"},
+		},
+		{
+			name: "Text payload and pattern",
+			addr: echo,
+			cfg:  UDPConfig{Payload: "PING", Expect: "^echo: PI.G$"},
+		},
+		{
+			name: "Hex payload and prefix",
+			addr: echo,
+			cfg:  UDPConfig{PayloadHex: "00ff10", ExpectHex: "6563686f3a2000ff"},
+		},
+		{
+			name:    "Pattern mismatch",
+			addr:    echo,
+			cfg:     UDPConfig{Payload: "PING", Expect: "PONG"},
+			wantErr: ErrResponseMismatch,
+		},
+		{
+			name:    "Prefix mismatch",
+			addr:    echo,
+			cfg:     UDPConfig{PayloadHex: "00ff10", ExpectHex: "00ff"},
+			wantErr: ErrResponseMismatch,
+		},
+		{
+			name:        "Port unreachable",
+			addr:        closed,
+			cfg:         UDPConfig{Payload: "PING"},
+			errContains: "connection refused",
+		},
+		{
+			name:        "Timeout",
+			addr:        silent,
+			cfg:         UDPConfig{Payload: "PING"},
+			errContains: "i/o timeout",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestBackend(t, "udp://"+tt.addr, UDP)
+			cfg.Timeout = 200 * time.Millisecond
+			cfg.UDP = tt.cfg
+			require.NoError(t, cfg.UDP.validate())
+
+			err := doUdp(context.Background(), cfg)
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.errContains != "":
+				assert.ErrorContains(t, err, tt.errContains)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("Invalid config", func(t *testing.T) {
+		for _, cfg := range []UDPConfig{
+			{Payload: "PING", PayloadHex: "00"},
+			{PayloadHex: "0g"},
+			{ExpectHex: "abc"},
+			{Expect: "("},
+		} {
+			assert.Error(t, cfg.validate(), "%+v", cfg)
+		}
+	})
+}
+
+// newTcpServer starts a TCP server handling each connection with handle.
+func newTcpServer(t *testing.T, handle func(conn net.Conn)) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func TestDoTcp(t *testing.T) {
+	pong := newTcpServer(t, func(conn net.Conn) {
+		buf := make([]byte, 64)
+		n, _ := conn.Read(buf)
+		if string(buf[:n]) == "PING\r\n" {
+			_, _ = io.WriteString(conn, "+PONG\r\n")
+		}
+	})
+	// The banner is sent in two segments
+	smtp := newTcpServer(t, func(conn net.Conn) {
+		_, _ = io.WriteString(conn, "22")
+		time.Sleep(20 * time.Millisecond)
+		_, _ = io.WriteString(conn, "0 mail.example.com ESMTP\r\n")
+	})
+	wedged := newTcpServer(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	unavailable := newTcpServer(t, func(conn net.Conn) {
+		_, _ = io.WriteString(conn, "421 Service not available\r\n")
+	})
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	tests := []struct {
+		name    string
+		addr    string
+		cfg     TCPConfig
+		wantErr error
+		// errContains is checked for errors without a sentinel.
+		errContains string
+	}{
+		{
+			name: "Connect only",
+			addr: wedged,
+		},
+		{
+			name:        "Connection refused",
+			addr:        closed,
+			errContains: "connection refused",
+		},
+		{
+			name: "Send and expect",
+			addr: pong,
+			cfg:  TCPConfig{Send: "PING\r\n", Expect: `^\+PONG`},
+		},
+		{
+			name:    "Unexpected response",
+			addr:    pong,
+			cfg:     TCPConfig{Send: "PING\r\n", Expect: `^-ERR`},
+			wantErr: ErrResponseMismatch,
+		},
+		{
+			name: "Banner in several segments",
+			addr: smtp,
+			cfg:  TCPConfig{Expect: `^220 `},
+		},
+		{
+			name:    "Unexpected banner",
+			addr:    unavailable,
+			cfg:     TCPConfig{Expect: `^220 `},
+			wantErr: ErrResponseMismatch,
+		},
+		{
+			name:    "Wedged",
+			addr:    wedged,
+			cfg:     TCPConfig{Send: "PING\r\n", Expect: `^\+PONG`, ReadTimeout: 50 * time.Millisecond},
+			wantErr: ErrResponseMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestBackend(t, "tcp://"+tt.addr, TCP)
+			cfg.TCP = tt.cfg
+			require.NoError(t, cfg.TCP.validate())
+
+			start := time.Now()
+			err := doTcp(context.Background(), cfg)
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.errContains != "":
+				assert.ErrorContains(t, err, tt.errContains)
+			default:
+				assert.NoError(t, err)
+			}
+			if tt.cfg.ReadTimeout > 0 {
+				assert.Less(t, time.Since(start), cfg.Timeout/2)
+			}
+		})
+	}
+
+	assert.Error(t, (&TCPConfig{Expect: "("}).validate())
+}
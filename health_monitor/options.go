@@ -84,3 +84,15 @@ func WithBackend(cfg *BackendConfig) Option {
 		return nil
 	}
 }
+
+// WithChecker sets the checker of a protocol for this health monitor,
+// overriding the checker registered with RegisterChecker or the built-in one.
+func WithChecker(protocol Protocol, checker Checker) Option {
+	return func(c *Config) error {
+		if c.checkers == nil {
+			c.checkers = make(map[Protocol]Checker)
+		}
+		c.checkers[protocol] = checker
+		return nil
+	}
+}
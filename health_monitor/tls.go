@@ -0,0 +1,122 @@
+package health_monitor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	ErrInvalidTLSConfig = fmt.Errorf("invalid TLS configuration")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type TLSConfig struct {
+	// CAFile is the path of a PEM bundle of CAs used to verify backend certificates.
+	// If empty, the system CAs are used.
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile are the paths of a PEM client certificate and key, for mutual TLS.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ServerName overrides the server name used for SNI and certificate verification,
+	// which is the host of the URL by default.
+	ServerName string `mapstructure:"server_name"`
+	// MinVersion is the minimum TLS version: "1.0", "1.1", "1.2" or "1.3".
+	// If empty, the Go default is used.
+	MinVersion string `mapstructure:"min_version"`
+	// InsecureSkipVerify disables verification of backend certificates if true.
+	// It is a pointer so that a backend can set false to verify certificates despite the global configuration.
+	InsecureSkipVerify *bool `mapstructure:"insecure_skip_verify"`
+	// ExpiryWarning logs a warning when a certificate of the backend expires within this duration.
+	// Zero or nil disables the warning. Expired certificates fail verification regardless.
+	ExpiryWarning *time.Duration `mapstructure:"expiry_warning"`
+
+	// tlsConfig is built by load.
+	tlsConfig *tls.Config
+}
+
+// inherit sets the fields of the configuration that are not set from parent, e.g. the global configuration.
+// The client certificate and key are inherited together.
+func (c *TLSConfig) inherit(parent *TLSConfig) {
+	if c.CAFile == "" {
+		c.CAFile = parent.CAFile
+	}
+	if c.CertFile == "" && c.KeyFile == "" {
+		c.CertFile, c.KeyFile = parent.CertFile, parent.KeyFile
+	}
+	if c.ServerName == "" {
+		c.ServerName = parent.ServerName
+	}
+	if c.MinVersion == "" {
+		c.MinVersion = parent.MinVersion
+	}
+	if c.InsecureSkipVerify == nil {
+		c.InsecureSkipVerify = parent.InsecureSkipVerify
+	}
+	if c.ExpiryWarning == nil {
+		c.ExpiryWarning = parent.ExpiryWarning
+	}
+}
+
+// load reads the CA bundle and client certificate and builds the TLS configuration.
+func (c *TLSConfig) load() error {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify != nil && *c.InsecureSkipVerify,
+	}
+
+	if c.MinVersion != "" {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return fmt.Errorf("%w: unknown min_version %q", ErrInvalidTLSConfig, c.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTLSConfig, err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%w: no certificates found in %s", ErrInvalidTLSConfig, c.CAFile)
+		}
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("%w: cert_file and key_file must be set together", ErrInvalidTLSConfig)
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTLSConfig, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	c.tlsConfig = cfg
+	return nil
+}
+
+// expiringCertificate returns the first certificate of the chain that expires within c.ExpiryWarning, if any.
+func (c *TLSConfig) expiringCertificate(state *tls.ConnectionState) *x509.Certificate {
+	if c.ExpiryWarning == nil || *c.ExpiryWarning <= 0 || state == nil {
+		return nil
+	}
+	deadline := time.Now().Add(*c.ExpiryWarning)
+	for _, cert := range state.PeerCertificates {
+		if cert.NotAfter.Before(deadline) {
+			return cert
+		}
+	}
+	return nil
+}
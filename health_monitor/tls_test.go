@@ -0,0 +1,291 @@
+package health_monitor
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"maglev-go/x/ptr"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	tlsCert tls.Certificate
+	// certFile and keyFile are PEM files of the certificate and key.
+	certFile string
+	keyFile  string
+}
+
+// newTestCert issues a certificate for the given DNS names, signed by parent, or self-signed if parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert, notAfter time.Time, dnsNames ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     dnsNames,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		tlsCert:  tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert},
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return c
+}
+
+// newTLSServer starts an HTTPS server with the given certificate, requiring client certificates
+// signed by clientCA if it is not nil.
+func newTLSServer(t *testing.T, cert *testCert, clientCA *testCert, maxVersion uint16) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert.tlsCert},
+		MaxVersion:   maxVersion,
+	}
+	if clientCA != nil {
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLS.ClientCAs = x509.NewCertPool()
+		server.TLS.ClientCAs.AddCert(clientCA.cert)
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDoHttpTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, time.Now().Add(24*time.Hour))
+	serverCert := newTestCert(t, "server", ca, time.Now().Add(24*time.Hour), "backend.internal")
+	clientCert := newTestCert(t, "client", ca, time.Now().Add(24*time.Hour))
+
+	server := newTLSServer(t, serverCert, nil, 0)
+	mtlsServer := newTLSServer(t, serverCert, ca, 0)
+	tls12Server := newTLSServer(t, serverCert, nil, tls.VersionTLS12)
+
+	tests := []struct {
+		name    string
+		server  *httptest.Server
+		cfg     TLSConfig
+		wantErr bool
+	}{
+		{
+			name:    "Unknown CA",
+			server:  server,
+			cfg:     TLSConfig{ServerName: "backend.internal"},
+			wantErr: true,
+		},
+		{
+			name:   "CA bundle and SNI",
+			server: server,
+			cfg:    TLSConfig{CAFile: ca.certFile, ServerName: "backend.internal"},
+		},
+		{
+			name:    "Wrong server name",
+			server:  server,
+			cfg:     TLSConfig{CAFile: ca.certFile, ServerName: "other.internal"},
+			wantErr: true,
+		},
+		{
+			name:   "Insecure skip verify",
+			server: server,
+			cfg:    TLSConfig{InsecureSkipVerify: ptr.ToPtr(true)},
+		},
+		{
+			name:    "Missing client certificate",
+			server:  mtlsServer,
+			cfg:     TLSConfig{CAFile: ca.certFile, ServerName: "backend.internal"},
+			wantErr: true,
+		},
+		{
+			name:   "Client certificate",
+			server: mtlsServer,
+			cfg:    TLSConfig{CAFile: ca.certFile, ServerName: "backend.internal", CertFile: clientCert.certFile, KeyFile: clientCert.keyFile},
+		},
+		{
+			name:    "Min version",
+			server:  tls12Server,
+			cfg:     TLSConfig{CAFile: ca.certFile, ServerName: "backend.internal", MinVersion: "1.3"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestBackend(t, tt.server.URL, HTTPS)
+			cfg.TLS = &tt.cfg
+			require.NoError(t, cfg.TLS.load())
+
+			err := doHttp(context.Background(), cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("Expiry warning", func(t *testing.T) {
+		expiringCert := newTestCert(t, "expiring", ca, time.Now().Add(48*time.Hour), "backend.internal")
+		expiringServer := newTLSServer(t, expiringCert, nil, 0)
+
+		for _, expiryWarning := range []time.Duration{24 * time.Hour, 72 * time.Hour} {
+			var logs bytes.Buffer
+			cfg := newTestBackend(t, expiringServer.URL, HTTPS)
+			cfg.TLS = &TLSConfig{CAFile: ca.certFile, ServerName: "backend.internal", ExpiryWarning: &expiryWarning}
+			require.NoError(t, cfg.TLS.load())
+
+			assert.NoError(t, doHttp(zerolog.New(&logs).WithContext(context.Background()), cfg))
+			assert.Equal(t, expiryWarning > 48*time.Hour, strings.Contains(logs.String(), "Backend certificate expires soon"))
+		}
+	})
+}
+
+func TestTLSConfigLoad(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, time.Now().Add(time.Hour))
+
+	for _, cfg := range []TLSConfig{
+		{MinVersion: "1.4"},
+		{CAFile: filepath.Join(t.TempDir(), "missing.crt")},
+		{CAFile: ca.keyFile},
+		{CertFile: ca.certFile},
+		{CertFile: ca.certFile, KeyFile: ca.certFile},
+	} {
+		assert.ErrorIs(t, cfg.load(), ErrInvalidTLSConfig, "%+v", cfg)
+	}
+
+	t.Run("LoadConfig", func(t *testing.T) {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		require.NoError(t, v.ReadConfig(strings.NewReader(`
+tls:
+  ca_file: `+ca.certFile+`
+  min_version: "1.2"
+  expiry_warning: 168h
+backends:
+  - name: global
+    url: https://127.0.0.1/health
+    protocol: https
+  - name: override
+    url: https://127.0.0.1/health
+    protocol: https
+    tls:
+      server_name: backend.internal
+      insecure_skip_verify: true
+`)))
+		hm, err := NewHealthMonitor(context.Background(), LoadConfig(v))
+		require.NoError(t, err)
+		require.NoError(t, hm.Start())
+		defer hm.Stop()
+
+		impl := hm.(*healthMonitorImpl)
+		global := impl.backends["global"].Cfg.TLS
+		assert.Equal(t, ca.certFile, global.CAFile)
+		assert.Equal(t, ptr.ToPtr(168*time.Hour), global.ExpiryWarning)
+		assert.Equal(t, uint16(tls.VersionTLS12), global.tlsConfig.MinVersion)
+		assert.NotNil(t, global.tlsConfig.RootCAs)
+
+		override := impl.backends["override"].Cfg.TLS
+		assert.Equal(t, "backend.internal", override.tlsConfig.ServerName)
+		assert.True(t, override.tlsConfig.InsecureSkipVerify)
+		// Fields that are not overridden are inherited
+		assert.Equal(t, ca.certFile, override.CAFile)
+		assert.NotNil(t, override.tlsConfig.RootCAs)
+		assert.Equal(t, uint16(tls.VersionTLS12), override.tlsConfig.MinVersion)
+		assert.Equal(t, ptr.ToPtr(168*time.Hour), override.ExpiryWarning)
+	})
+
+	t.Run("Explicit overrides", func(t *testing.T) {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		require.NoError(t, v.ReadConfig(strings.NewReader(`
+tls:
+  insecure_skip_verify: true
+  expiry_warning: 168h
+backends:
+  - name: inherited
+    url: https://127.0.0.1/health
+    protocol: https
+  - name: verified
+    url: https://127.0.0.1/health
+    protocol: https
+    tls:
+      insecure_skip_verify: false
+      expiry_warning: 0s
+`)))
+		hm, err := NewHealthMonitor(context.Background(), LoadConfig(v))
+		require.NoError(t, err)
+		require.NoError(t, hm.Start())
+		defer hm.Stop()
+
+		impl := hm.(*healthMonitorImpl)
+		inherited := impl.backends["inherited"].Cfg.TLS
+		assert.True(t, inherited.tlsConfig.InsecureSkipVerify)
+		assert.Equal(t, ptr.ToPtr(168*time.Hour), inherited.ExpiryWarning)
+
+		// A backend can verify certificates and disable the expiry warning despite the global configuration
+		verified := impl.backends["verified"].Cfg.TLS
+		assert.False(t, verified.tlsConfig.InsecureSkipVerify)
+		assert.Equal(t, ptr.ToPtr(time.Duration(0)), verified.ExpiryWarning)
+	})
+
+	t.Run("Server name override", func(t *testing.T) {
+		serverCert := newTestCert(t, "server", ca, time.Now().Add(time.Hour), "backend.internal")
+		clientCert := newTestCert(t, "client", ca, time.Now().Add(time.Hour))
+		server := newTLSServer(t, serverCert, ca, 0)
+
+		hm, err := NewHealthMonitor(context.Background(), WithConfig(&Config{
+			TLS: TLSConfig{CAFile: ca.certFile, CertFile: clientCert.certFile, KeyFile: clientCert.keyFile},
+		}))
+		require.NoError(t, err)
+		cfg := newTestBackend(t, server.URL, HTTPS)
+		cfg.TLS = &TLSConfig{ServerName: "backend.internal"}
+		require.NoError(t, hm.Add(cfg))
+
+		// The CA bundle and the client certificate are inherited, the server name of the URL is 127.0.0.1
+		assert.Len(t, cfg.TLS.tlsConfig.Certificates, 1)
+		assert.NoError(t, doHttp(context.Background(), cfg))
+	})
+}
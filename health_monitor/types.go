@@ -14,6 +14,26 @@ type Backend struct {
 	// statusStreak is the number of consecutive health checks that have passed or failed.
 	// Positive for passing checks, negative for failing checks.
 	statusStreak int
+	// weight is the last weight reported by the checker in percent, or the minimum weight of the probes.
+	weight int
+	// probes is the state of the probes of a composite backend, in the order of Cfg.Probes.
+	probes []*Backend
+}
+
+func newBackend(cfg *BackendConfig, healthy bool) *Backend {
+	b := &Backend{
+		Cfg:     cfg,
+		healthy: healthy,
+		weight:  100,
+	}
+	for _, probe := range cfg.Probes {
+		b.probes = append(b.probes, &Backend{
+			Cfg:     probe,
+			healthy: healthy,
+			weight:  100,
+		})
+	}
+	return b
 }
 
 type HealthNoti struct {
@@ -23,6 +43,12 @@ type HealthNoti struct {
 	// Timestamp is the time when the health check was performed.
 	// If nil, the result will never change again. For example, when the backend is removed.
 	Timestamp *time.Time
+	// Weight is the weight of the backend in percent, 100 unless reported by its checker, e.g. an agent.
+	// A healthy backend with a weight of 0 is draining: it should receive no new connections.
+	// For a composite backend, it is the minimum weight of its probes.
+	Weight int
+	// Probes is the health of each probe of a composite backend, by name. Nil for other backends.
+	Probes map[string]bool
 }
 
 func (b *Backend) toNoti(opts ...func(noti *HealthNoti)) *HealthNoti {
@@ -30,8 +56,15 @@ func (b *Backend) toNoti(opts ...func(noti *HealthNoti)) *HealthNoti {
 		Url:       b.Cfg.Url,
 		Name:      b.Cfg.Name,
 		Healthy:   b.healthy,
+		Weight:    b.weight,
 		Timestamp: ptr.ToPtr(time.Now()),
 	}
+	if len(b.probes) > 0 {
+		noti.Probes = make(map[string]bool, len(b.probes))
+		for _, probe := range b.probes {
+			noti.Probes[probe.Cfg.Name] = probe.healthy
+		}
+	}
 	for _, opt := range opts {
 		opt(noti)
 	}
@@ -0,0 +1,201 @@
+package tuple_hash
+
+import (
+	"encoding/binary"
+)
+
+// IP protocol numbers of tunneling protocols recognized by Decap.
+const (
+	ProtoIPIP uint8 = 4
+	ProtoIPv6 uint8 = 41
+	ProtoGRE  uint8 = 47
+)
+
+// Default UDP destination ports of UDP tunneling protocols.
+const (
+	DefaultVXLANPort uint16 = 4789
+	DefaultGUEPort   uint16 = 6080
+)
+
+// etherTypeTEB is the GRE protocol type of transparent Ethernet bridging, i.e. Ethernet over GRE.
+const etherTypeTEB uint16 = 0x6558
+
+// Decap extracts the inner packet of tunneled traffic, so that it is hashed by the inner flow
+// instead of the tunnel endpoints. It recognizes GRE, IPIP/IP6IP6 (and mixed), VXLAN and GUE.
+//
+// The zero value does not decapsulate.
+type Decap struct {
+	// MaxDepth is the maximum number of encapsulation layers to strip.
+	MaxDepth int `mapstructure:"max_depth"`
+	// VXLANPorts are the UDP destination ports of VXLAN. Defaults to DefaultVXLANPort.
+	VXLANPorts []uint16 `mapstructure:"vxlan_ports"`
+	// GUEPorts are the UDP destination ports of GUE. Defaults to DefaultGUEPort.
+	GUEPorts []uint16 `mapstructure:"gue_ports"`
+}
+
+// Decapsulate strips up to MaxDepth encapsulation layers, and returns the headers of the innermost packet
+// and the number of layers stripped. Packets that are not tunneled are returned as they are.
+//
+// If an inner packet fails to parse, the headers of the innermost packet that was parsed are returned
+// along with the error. Offsets in parse errors are relative to the tunneling header.
+// It does not allocate unless it returns an error.
+func (d *Decap) Decapsulate(h Headers) (Headers, int, error) {
+	for depth := 0; depth < d.MaxDepth; depth++ {
+		inner, ok, err := d.decapsulate(&h)
+		if err != nil {
+			return h, depth, err
+		}
+		if !ok {
+			return h, depth, nil
+		}
+		h = inner
+	}
+	return h, d.MaxDepth, nil
+}
+
+// decapsulate strips one encapsulation layer of h.
+// Returns false if h is not a tunneled packet.
+func (d *Decap) decapsulate(h *Headers) (Headers, bool, error) {
+	// The inner packet of a fragment is incomplete, and all fragments must hash by the same outer tuple
+	if h.IsFragment() {
+		return Headers{}, false, nil
+	}
+
+	var (
+		inner Headers
+		err   error
+	)
+	switch h.Proto {
+	case ProtoIPIP, ProtoIPv6:
+		inner, err = parseInner(h.Transport, 0, false)
+	case ProtoGRE:
+		return decapsulateGRE(h.Transport)
+	case ProtoUDP:
+		// Headers of truncated packets, or built by hand, may lack the UDP header
+		if len(h.Transport) < 8 {
+			return Headers{}, false, &ParseError{Layer: "udp", Offset: 0, Err: ErrTruncated}
+		}
+		switch {
+		case matchPort(h.DstPort, d.VXLANPorts, DefaultVXLANPort):
+			return decapsulateVXLAN(h.Transport[8:])
+		case matchPort(h.DstPort, d.GUEPorts, DefaultGUEPort):
+			return decapsulateGUE(h.Transport[8:])
+		default:
+			return Headers{}, false, nil
+		}
+	default:
+		return Headers{}, false, nil
+	}
+	if err != nil {
+		return Headers{}, false, err
+	}
+	return inner, true, nil
+}
+
+func decapsulateGRE(gre []byte) (Headers, bool, error) {
+	if len(gre) < 4 {
+		return Headers{}, false, &ParseError{Layer: "gre", Offset: 0, Err: ErrTruncated}
+	}
+	flags := binary.BigEndian.Uint16(gre[0:])
+	if flags&0x0007 != 0 {
+		// Version 1 is the enhanced GRE of PPTP, which carries PPP
+		return Headers{}, false, nil
+	}
+	headerLen := 4
+	// checksum, key and sequence number present
+	for _, bit := range []uint16{0x8000, 0x2000, 0x1000} {
+		if flags&bit != 0 {
+			headerLen += 4
+		}
+	}
+	if len(gre) < headerLen {
+		return Headers{}, false, &ParseError{Layer: "gre", Offset: 0, Err: ErrTruncated}
+	}
+
+	switch binary.BigEndian.Uint16(gre[2:]) {
+	case EtherTypeIPv4, EtherTypeIPv6:
+		inner, err := parseInner(gre, headerLen, false)
+		return inner, err == nil, err
+	case etherTypeTEB:
+		inner, err := parseInner(gre, headerLen, true)
+		return inner, err == nil, err
+	default:
+		return Headers{}, false, nil
+	}
+}
+
+func decapsulateVXLAN(vxlan []byte) (Headers, bool, error) {
+	if len(vxlan) < 8 {
+		return Headers{}, false, &ParseError{Layer: "vxlan", Offset: 0, Err: ErrTruncated}
+	}
+	if vxlan[0]&0x08 == 0 {
+		// The VNI is not valid
+		return Headers{}, false, &ParseError{Layer: "vxlan", Offset: 0, Err: ErrMalformed}
+	}
+	inner, err := parseInner(vxlan, 8, true)
+	return inner, err == nil, err
+}
+
+func decapsulateGUE(gue []byte) (Headers, bool, error) {
+	if len(gue) < 1 {
+		return Headers{}, false, &ParseError{Layer: "gue", Offset: 0, Err: ErrTruncated}
+	}
+	switch gue[0] >> 6 {
+	case 0:
+		if len(gue) < 4 {
+			return Headers{}, false, &ParseError{Layer: "gue", Offset: 0, Err: ErrTruncated}
+		}
+		if gue[0]&0x20 != 0 {
+			// Control message
+			return Headers{}, false, nil
+		}
+		headerLen := 4 + int(gue[0]&0x1f)*4
+		if len(gue) < headerLen {
+			return Headers{}, false, &ParseError{Layer: "gue", Offset: 0, Err: ErrTruncated}
+		}
+		switch gue[1] {
+		case ProtoIPIP, ProtoIPv6:
+			inner, err := parseInner(gue, headerLen, false)
+			return inner, err == nil, err
+		default:
+			// Transport protocols carried without an inner IP header keep the outer flow
+			return Headers{}, false, nil
+		}
+	case 1:
+		// Version 1 directly encapsulates IPv4 or IPv6 without a GUE header
+		inner, err := parseInner(gue, 0, false)
+		return inner, err == nil, err
+	default:
+		return Headers{}, false, &ParseError{Layer: "gue", Offset: 0, Err: ErrUnsupported}
+	}
+}
+
+// parseInner parses the inner packet at data[offset:].
+func parseInner(data []byte, offset int, ethernet bool) (Headers, error) {
+	var (
+		h   Headers
+		err error
+	)
+	if ethernet {
+		err = parseEthernet(data, offset, &h)
+	} else {
+		err = parseIP(data, offset, &h, false)
+	}
+	if err != nil {
+		return Headers{}, err
+	}
+	return h, nil
+}
+
+// matchPort returns true if port is one of ports, or defaultPort if ports is empty.
+func matchPort(port uint16, ports []uint16, defaultPort uint16) bool {
+	if len(ports) == 0 {
+		return port == defaultPort
+	}
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package tuple_hash
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecap(t *testing.T) {
+	innerV4 := testIPv4{src: "198.51.100.7", dst: "10.0.0.100", proto: ProtoTCP, payload: tcpHeader(51234, 80)}.bytes()
+	innerV6 := testIPv6{src: "2001:db8:c::7", dst: "2001:db8:f::100", next: ProtoUDP, payload: udpHeader(40000, 53, []byte("query"))}.bytes()
+	flowV4 := tuple("198.51.100.7", 51234, "10.0.0.100", 80, ProtoTCP)
+	flowV6 := tuple("2001:db8:c::7", 40000, "2001:db8:f::100", 53, ProtoUDP)
+
+	tunnelV4 := func(proto uint8, payload []byte) []byte {
+		return testIPv4{src: "192.0.2.1", dst: "192.0.2.2", proto: proto, payload: payload}.bytes()
+	}
+	tunnelV6 := func(proto uint8, payload []byte) []byte {
+		return testIPv6{src: "2001:db8:1::1", dst: "2001:db8:1::2", next: proto, payload: payload}.bytes()
+	}
+
+	tests := []struct {
+		name     string
+		decap    Decap
+		packet   []byte
+		expected FiveTuple
+		depth    int
+	}{
+		{
+			name:     "Not tunneled",
+			decap:    Decap{MaxDepth: 1},
+			packet:   innerV4,
+			expected: flowV4,
+			depth:    0,
+		},
+		{
+			name:     "Zero value does not decapsulate",
+			packet:   tunnelV4(ProtoIPIP, innerV4),
+			expected: tuple("192.0.2.1", 0, "192.0.2.2", 0, ProtoIPIP),
+			depth:    0,
+		},
+		{
+			name:     "IPIP",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV4(ProtoIPIP, innerV4),
+			expected: flowV4,
+			depth:    1,
+		},
+		{
+			name:     "IP6IP6",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV6(ProtoIPv6, innerV6),
+			expected: flowV6,
+			depth:    1,
+		},
+		{
+			name:     "6in4",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV4(ProtoIPv6, innerV6),
+			expected: flowV6,
+			depth:    1,
+		},
+		{
+			name:     "GRE",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV4(ProtoGRE, concat(greHeader(EtherTypeIPv4, false), innerV4)),
+			expected: flowV4,
+			depth:    1,
+		},
+		{
+			name:     "GRE with key",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV6(ProtoGRE, concat(greHeader(EtherTypeIPv6, true), innerV6)),
+			expected: flowV6,
+			depth:    1,
+		},
+		{
+			name:     "GRE transparent Ethernet bridging",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV4(ProtoGRE, concat(greHeader(etherTypeTEB, false), ethernet(EtherTypeIPv4, []uint16{10}, innerV4))),
+			expected: flowV4,
+			depth:    1,
+		},
+		{
+			name:     "VXLAN",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV4(ProtoUDP, udpHeader(49152, DefaultVXLANPort, concat(vxlanHeader(42), ethernet(EtherTypeIPv6, nil, innerV6)))),
+			expected: flowV6,
+			depth:    1,
+		},
+		{
+			name:     "VXLAN on a custom port",
+			decap:    Decap{MaxDepth: 1, VXLANPorts: []uint16{8472}},
+			packet:   tunnelV4(ProtoUDP, udpHeader(49152, 8472, concat(vxlanHeader(42), ethernet(EtherTypeIPv4, nil, innerV4)))),
+			expected: flowV4,
+			depth:    1,
+		},
+		{
+			name:     "GUE version 0",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV6(ProtoUDP, udpHeader(49152, DefaultGUEPort, concat(gueHeader(ProtoIPIP, 1), innerV4))),
+			expected: flowV4,
+			depth:    1,
+		},
+		{
+			name:     "GUE version 1",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV4(ProtoUDP, udpHeader(49152, DefaultGUEPort, innerV6)),
+			expected: flowV6,
+			depth:    1,
+		},
+		{
+			name:     "Nested VXLAN, GRE and IPIP",
+			decap:    Decap{MaxDepth: 3},
+			packet:   tunnelV4(ProtoUDP, udpHeader(49152, DefaultVXLANPort, concat(vxlanHeader(1), ethernet(EtherTypeIPv4, nil, tunnelV4(ProtoGRE, concat(greHeader(EtherTypeIPv4, true), tunnelV4(ProtoIPIP, innerV4))))))),
+			expected: flowV4,
+			depth:    3,
+		},
+		{
+			name:     "Nesting beyond the maximum depth",
+			decap:    Decap{MaxDepth: 2},
+			packet:   tunnelV4(ProtoUDP, udpHeader(49152, DefaultVXLANPort, concat(vxlanHeader(1), ethernet(EtherTypeIPv4, nil, tunnelV4(ProtoGRE, concat(greHeader(EtherTypeIPv4, true), tunnelV4(ProtoIPIP, innerV4))))))),
+			expected: tuple("192.0.2.1", 0, "192.0.2.2", 0, ProtoIPIP),
+			depth:    2,
+		},
+		{
+			name:     "Enhanced GRE is not decapsulated",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV4(ProtoGRE, concat([]byte{0x30, 0x81, 0x88, 0x0b, 0, 0, 0, 0, 0, 0, 0, 0}, innerV4)),
+			expected: tuple("192.0.2.1", 0, "192.0.2.2", 0, ProtoGRE),
+			depth:    0,
+		},
+		{
+			name:     "GUE control message is not decapsulated",
+			decap:    Decap{MaxDepth: 1},
+			packet:   tunnelV4(ProtoUDP, udpHeader(49152, DefaultGUEPort, []byte{0x20, 0, 0, 0})),
+			expected: tuple("192.0.2.1", 49152, "192.0.2.2", DefaultGUEPort, ProtoUDP),
+			depth:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseIP(tt.packet)
+			assert.NoError(t, err)
+
+			inner, depth, err := tt.decap.Decapsulate(h)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.depth, depth)
+			assert.Equal(t, tt.expected, inner.FiveTuple)
+		})
+	}
+
+	t.Run("Malformed inner packet", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			packet []byte
+			err    error
+		}{
+			{name: "Truncated inner IP", packet: tunnelV4(ProtoIPIP, innerV4[:10]), err: ErrTruncated},
+			{name: "Truncated GRE", packet: tunnelV4(ProtoGRE, greHeader(EtherTypeIPv4, true)[:6]), err: ErrTruncated},
+			{name: "Truncated VXLAN", packet: tunnelV4(ProtoUDP, udpHeader(49152, DefaultVXLANPort, []byte{0x08, 0, 0})), err: ErrTruncated},
+			{name: "VXLAN without VNI", packet: tunnelV4(ProtoUDP, udpHeader(49152, DefaultVXLANPort, concat(make([]byte, 8), ethernet(EtherTypeIPv4, nil, innerV4)))), err: ErrMalformed},
+			{name: "Unknown GUE version", packet: tunnelV4(ProtoUDP, udpHeader(49152, DefaultGUEPort, []byte{0x80, 0, 0, 0})), err: ErrUnsupported},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				h, err := ParseIP(tt.packet)
+				assert.NoError(t, err)
+
+				inner, depth, err := (&Decap{MaxDepth: 1}).Decapsulate(h)
+				assert.ErrorIs(t, err, tt.err)
+				assert.Zero(t, depth)
+				assert.Equal(t, h.FiveTuple, inner.FiveTuple)
+			})
+		}
+	})
+
+	t.Run("Truncated UDP header", func(t *testing.T) {
+		for _, port := range []uint16{DefaultVXLANPort, DefaultGUEPort} {
+			h := Headers{FiveTuple: tuple("192.0.2.1", 49152, "192.0.2.2", port, ProtoUDP), Transport: make([]byte, 4)}
+			policy := Policy{Decap: Decap{MaxDepth: 2}}
+
+			inner, depth, err := policy.Decap.Decapsulate(h)
+			assert.ErrorIs(t, err, ErrTruncated)
+			assert.Zero(t, depth)
+			assert.Equal(t, h.FiveTuple, inner.FiveTuple)
+
+			h.Transport = nil
+			assert.NotPanics(t, func() { _ = policy.Hash64(&h) })
+			assert.Equal(t, Policy{}.Hash64(&h), policy.Hash64(&h))
+		}
+	})
+
+	t.Run("Fragments are not decapsulated", func(t *testing.T) {
+		policy := Policy{Decap: Decap{MaxDepth: 2}}
+		// The inner packet of the first fragment parses, but the last fragment only carries the rest of it
+		vxlan := udpHeader(49152, DefaultVXLANPort, concat(vxlanHeader(42), ethernet(EtherTypeIPv4, nil, innerV4)))
+		first, err := ParseIP(testIPv4{src: "192.0.2.1", dst: "192.0.2.2", proto: ProtoUDP, fragment: 0x2000, payload: vxlan}.bytes())
+		assert.NoError(t, err)
+		last, err := ParseIP(testIPv4{src: "192.0.2.1", dst: "192.0.2.2", proto: ProtoUDP, fragment: 10, payload: []byte("rest")}.bytes())
+		assert.NoError(t, err)
+
+		for _, h := range []Headers{first, last} {
+			inner, depth, err := policy.Decap.Decapsulate(h)
+			assert.NoError(t, err)
+			assert.Zero(t, depth)
+			assert.Equal(t, h.FiveTuple, inner.FiveTuple)
+		}
+		assert.Equal(t, policy.Hash64(&first), policy.Hash64(&last))
+	})
+
+	t.Run("Policy hashes by the inner flow", func(t *testing.T) {
+		policy := Policy{Decap: Decap{MaxDepth: 2}}
+		direct, err := ParseIP(innerV4)
+		assert.NoError(t, err)
+		tunneled, err := ParseIP(tunnelV4(ProtoGRE, concat(greHeader(EtherTypeIPv4, true), innerV4)))
+		assert.NoError(t, err)
+		other, err := ParseIP(tunnelV4(ProtoGRE, concat(greHeader(EtherTypeIPv4, true), testIPv4{src: "198.51.100.8", dst: "10.0.0.100", proto: ProtoTCP, payload: tcpHeader(51234, 80)}.bytes())))
+		assert.NoError(t, err)
+
+		assert.Equal(t, policy.Hash64(&direct), policy.Hash64(&tunneled))
+		assert.NotEqual(t, policy.Hash64(&tunneled), policy.Hash64(&other))
+		assert.Equal(t, Policy{}.Hash64(&tunneled), Policy{}.Hash64(&other))
+
+		allocs := testing.AllocsPerRun(1000, func() {
+			_ = policy.Hash64(&tunneled)
+		})
+		assert.Zero(t, allocs)
+	})
+}
+
+func greHeader(protocol uint16, key bool) []byte {
+	b := make([]byte, 4, 8)
+	if key {
+		binary.BigEndian.PutUint16(b[0:], 0x2000)
+		b = append(b, 0, 0, 0x12, 0x34)
+	}
+	binary.BigEndian.PutUint16(b[2:], protocol)
+	return b
+}
+
+func vxlanHeader(vni uint32) []byte {
+	b := make([]byte, 8)
+	b[0] = 0x08
+	binary.BigEndian.PutUint32(b[4:], vni<<8)
+	return b
+}
+
+// gueHeader returns a version 0 GUE header with the given number of 4-byte extension words.
+func gueHeader(proto uint8, words int) []byte {
+	b := make([]byte, 4+words*4)
+	b[0] = byte(words)
+	b[1] = proto
+	return b
+}
@@ -0,0 +1,145 @@
+package tuple_hash
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidFiveTuple = fmt.Errorf("invalid 5-tuple")
+)
+
+var protoNames = map[uint8]string{
+	ProtoICMP:   "icmp",
+	ProtoTCP:    "tcp",
+	ProtoUDP:    "udp",
+	ProtoICMPv6: "icmpv6",
+	ProtoSCTP:   "sctp",
+}
+
+// FiveTuple identifies a flow by its source IP, source port,
+// destination IP, destination port and IP protocol number.
+// Ports are zero for protocols without ports.
+//
+// It is comparable, so it can be used as a map key, e.g. for connection tracking.
+// Its text form is "<proto> <src>:<port>-><dst>:<port>", for example "tcp 10.0.0.1:1234->10.0.0.2:80"
+// or "udp [2001:db8::1]:5353->[2001:db8::2]:53". Protocols without a name are written as their number.
+type FiveTuple struct {
+	SrcIP   netip.Addr
+	DstIP   netip.Addr
+	SrcPort uint16
+	DstPort uint16
+	Proto   uint8
+}
+
+// ParseFiveTuple parses the text form of a 5-tuple.
+func ParseFiveTuple(s string) (FiveTuple, error) {
+	protoStr, endpoints, ok := strings.Cut(s, " ")
+	if !ok {
+		return FiveTuple{}, fmt.Errorf("%w: %q: missing protocol", ErrInvalidFiveTuple, s)
+	}
+	srcStr, dstStr, ok := strings.Cut(endpoints, "->")
+	if !ok {
+		return FiveTuple{}, fmt.Errorf("%w: %q: missing \"->\"", ErrInvalidFiveTuple, s)
+	}
+
+	proto, err := parseProto(protoStr)
+	if err != nil {
+		return FiveTuple{}, fmt.Errorf("%w: %q: %v", ErrInvalidFiveTuple, s, err)
+	}
+	src, err := netip.ParseAddrPort(srcStr)
+	if err != nil {
+		return FiveTuple{}, fmt.Errorf("%w: %q: %v", ErrInvalidFiveTuple, s, err)
+	}
+	dst, err := netip.ParseAddrPort(dstStr)
+	if err != nil {
+		return FiveTuple{}, fmt.Errorf("%w: %q: %v", ErrInvalidFiveTuple, s, err)
+	}
+
+	return FiveTuple{
+		SrcIP:   src.Addr(),
+		DstIP:   dst.Addr(),
+		SrcPort: src.Port(),
+		DstPort: dst.Port(),
+		Proto:   proto,
+	}, nil
+}
+
+func parseProto(s string) (uint8, error) {
+	for proto, name := range protoNames {
+		if strings.EqualFold(name, s) {
+			return proto, nil
+		}
+	}
+	proto, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown protocol %q", s)
+	}
+	return uint8(proto), nil
+}
+
+func (t FiveTuple) String() string {
+	proto, ok := protoNames[t.Proto]
+	if !ok {
+		proto = strconv.Itoa(int(t.Proto))
+	}
+	return proto + " " +
+		netip.AddrPortFrom(t.SrcIP, t.SrcPort).String() + "->" +
+		netip.AddrPortFrom(t.DstIP, t.DstPort).String()
+}
+
+// Reverse returns the 5-tuple of the opposite direction of the flow.
+func (t FiveTuple) Reverse() FiveTuple {
+	return FiveTuple{
+		SrcIP:   t.DstIP,
+		DstIP:   t.SrcIP,
+		SrcPort: t.DstPort,
+		DstPort: t.SrcPort,
+		Proto:   t.Proto,
+	}
+}
+
+// Hash returns the same hash as the package level Hash, without allocating.
+func (t FiveTuple) Hash() uint32 {
+	var buf [tupleLen]byte
+	putTuple(&buf, t.SrcIP, t.SrcPort, t.DstIP, t.DstPort, t.Proto)
+	// The accelerated crc32 implementations make buf escape to the heap,
+	// the table-driven loop is as fast for a buffer this short.
+	crc := ^uint32(0)
+	for _, b := range buf {
+		crc = crc32.IEEETable[byte(crc)^b] ^ crc>>8
+	}
+	return ^crc
+}
+
+// Hash64 returns the same hash as the package level Hash64.
+func (t FiveTuple) Hash64() uint64 {
+	return Hash64(t.SrcIP, t.SrcPort, t.DstIP, t.DstPort, t.Proto)
+}
+
+// MarshalText implements encoding.TextMarshaler, which is also used for JSON.
+// The zero value is marshaled as an empty string.
+func (t FiveTuple) MarshalText() ([]byte, error) {
+	if t == (FiveTuple{}) {
+		return []byte{}, nil
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, which is also used for JSON.
+// An empty string is unmarshaled as the zero value.
+func (t *FiveTuple) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*t = FiveTuple{}
+		return nil
+	}
+	parsed, err := ParseFiveTuple(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
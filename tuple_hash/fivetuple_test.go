@@ -0,0 +1,106 @@
+package tuple_hash
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestFiveTuple(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected FiveTuple
+	}{
+		{name: "IPv4 TCP", text: "tcp 10.0.0.1:1234->10.0.0.2:80", expected: tuple("10.0.0.1", 1234, "10.0.0.2", 80, ProtoTCP)},
+		{name: "IPv6 UDP", text: "udp [2001:db8::1]:5353->[2001:db8::2]:53", expected: tuple("2001:db8::1", 5353, "2001:db8::2", 53, ProtoUDP)},
+		{name: "SCTP", text: "sctp 10.0.0.1:36412->10.0.0.2:38412", expected: tuple("10.0.0.1", 36412, "10.0.0.2", 38412, ProtoSCTP)},
+		{name: "ICMP", text: "icmp 10.0.0.1:0->10.0.0.2:0", expected: tuple("10.0.0.1", 0, "10.0.0.2", 0, ProtoICMP)},
+		{name: "ICMPv6", text: "icmpv6 [fe80::1]:0->[ff02::1]:0", expected: tuple("fe80::1", 0, "ff02::1", 0, ProtoICMPv6)},
+		{name: "Unnamed protocol", text: "47 192.0.2.1:0->192.0.2.2:0", expected: tuple("192.0.2.1", 0, "192.0.2.2", 0, ProtoGRE)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseFiveTuple(tt.text)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, parsed)
+			assert.Equal(t, tt.text, parsed.String())
+
+			reversed := parsed.Reverse()
+			assert.Equal(t, parsed.SrcIP, reversed.DstIP)
+			assert.Equal(t, parsed.DstIP, reversed.SrcIP)
+			assert.Equal(t, parsed.SrcPort, reversed.DstPort)
+			assert.Equal(t, parsed.DstPort, reversed.SrcPort)
+			assert.Equal(t, parsed.Proto, reversed.Proto)
+			assert.Equal(t, parsed, reversed.Reverse())
+		})
+	}
+
+	t.Run("Protocol names are case insensitive", func(t *testing.T) {
+		parsed, err := ParseFiveTuple("TCP 10.0.0.1:1234->10.0.0.2:80")
+		assert.NoError(t, err)
+		assert.Equal(t, tuple("10.0.0.1", 1234, "10.0.0.2", 80, ProtoTCP), parsed)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, text := range []string{
+			"",
+			"tcp",
+			"tcp 10.0.0.1:1234 10.0.0.2:80",
+			"foo 10.0.0.1:1234->10.0.0.2:80",
+			"256 10.0.0.1:1234->10.0.0.2:80",
+			"tcp 10.0.0.1->10.0.0.2:80",
+			"tcp 10.0.0.1:1234->10.0.0.2:65536",
+			"tcp 2001:db8::1:1234->10.0.0.2:80",
+		} {
+			_, err := ParseFiveTuple(text)
+			assert.ErrorIs(t, err, ErrInvalidFiveTuple, "%q", text)
+		}
+	})
+
+	t.Run("Map key", func(t *testing.T) {
+		conns := map[FiveTuple]int{}
+		conns[tuple("10.0.0.1", 1234, "10.0.0.2", 80, ProtoTCP)]++
+		parsed, err := ParseFiveTuple("tcp 10.0.0.1:1234->10.0.0.2:80")
+		assert.NoError(t, err)
+		conns[parsed]++
+		conns[parsed.Reverse()]++
+		assert.Equal(t, map[FiveTuple]int{parsed: 2, parsed.Reverse(): 1}, conns)
+	})
+
+	t.Run("JSON round trip", func(t *testing.T) {
+		type conn struct {
+			Flow  FiveTuple   `json:"flow"`
+			Flows []FiveTuple `json:"flows"`
+			Unset FiveTuple   `json:"unset"`
+		}
+		in := conn{
+			Flow:  tuple("10.0.0.1", 1234, "10.0.0.2", 80, ProtoTCP),
+			Flows: []FiveTuple{tuple("2001:db8::1", 5353, "2001:db8::2", 53, ProtoUDP)},
+		}
+		data, err := json.Marshal(in)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"flow":"tcp 10.0.0.1:1234->10.0.0.2:80","flows":["udp [2001:db8::1]:5353->[2001:db8::2]:53"],"unset":""}`, string(data))
+
+		var out conn
+		assert.NoError(t, json.Unmarshal(data, &out))
+		assert.Equal(t, in, out)
+
+		assert.ErrorIs(t, json.Unmarshal([]byte(`{"flow":"tcp nowhere"}`), &out), ErrInvalidFiveTuple)
+	})
+
+	t.Run("Hash", func(t *testing.T) {
+		ft := tuple("10.0.0.1", 1234, "10.0.0.2", 80, ProtoTCP)
+		expected, err := Hash(net.ParseIP("10.0.0.1"), 1234, net.ParseIP("10.0.0.2"), 80, ProtoTCP)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, ft.Hash())
+		assert.Equal(t, Hash64(ft.SrcIP, ft.SrcPort, ft.DstIP, ft.DstPort, ft.Proto), ft.Hash64())
+
+		allocs := testing.AllocsPerRun(1000, func() {
+			_ = ft.Hash()
+		})
+		assert.Zero(t, allocs)
+	})
+}
@@ -0,0 +1,66 @@
+package tuple_hash
+
+import (
+	"fmt"
+)
+
+var (
+	ErrNotICMPError = fmt.Errorf("not an ICMP error message")
+)
+
+// icmpHeaderLen is the length of the ICMP header preceding the quoted packet.
+const icmpHeaderLen = 8
+
+// IsICMPError returns true if the packet is an ICMPv4 or ICMPv6 error message,
+// which quotes the header of the packet that caused the error.
+// Fragmented messages, including their first fragment, are not followed, so that all their fragments hash the same.
+func IsICMPError(h *Headers) bool {
+	if h.IsFragment() || len(h.Transport) < 1 {
+		return false
+	}
+	switch {
+	case h.IPVersion == 4 && h.Proto == ProtoICMP:
+		switch h.Transport[0] {
+		// destination unreachable, source quench, redirect, time exceeded, parameter problem
+		case 3, 4, 5, 11, 12:
+			return true
+		}
+	case h.IPVersion == 6 && h.Proto == ProtoICMPv6:
+		// destination unreachable, packet too big, time exceeded, parameter problem
+		return h.Transport[0] >= 1 && h.Transport[0] <= 4
+	}
+	return false
+}
+
+// ParseICMPError parses the packet quoted in the ICMP error message h, and returns its headers
+// with source and destination swapped.
+//
+// The quoted packet is one sent by the load balanced service, e.g. a response that exceeded the path MTU,
+// so the reversed tuple is the one of the flow the error belongs to. Hashing it sends the error to the
+// backend serving that flow.
+//
+// The flow label of the quoted packet is set by the backend, and never matches the one of the client,
+// so FlowLabel is cleared and the error is hashed by the ports of the flow, as an unlabeled packet.
+//
+// The quoted packet may be truncated after the first 8 bytes of its transport header.
+// Network and Transport reference the quoted packet, and offsets in parse errors are relative to h.Transport.
+// Returns ErrNotICMPError if h is not an ICMP error message. It does not allocate unless it returns an error.
+func ParseICMPError(h *Headers) (Headers, error) {
+	if !IsICMPError(h) {
+		return Headers{}, ErrNotICMPError
+	}
+
+	var inner Headers
+	if err := parseIP(h.Transport, icmpHeaderLen, &inner, true); err != nil {
+		return Headers{}, err
+	}
+	if inner.IPVersion != h.IPVersion {
+		return Headers{}, &ParseError{Layer: "icmp", Offset: 0, Err: ErrMalformed}
+	}
+
+	inner.SrcIP, inner.DstIP = inner.DstIP, inner.SrcIP
+	inner.SrcPort, inner.DstPort = inner.DstPort, inner.SrcPort
+	inner.VLANs, inner.VLANID = h.VLANs, h.VLANID
+	inner.FlowLabel = 0
+	return inner, nil
+}
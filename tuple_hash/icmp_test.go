@@ -0,0 +1,173 @@
+package tuple_hash
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseICMPError(t *testing.T) {
+	// The forward flow from the client to the VIP
+	forwardV4 := testIPv4{src: "198.51.100.7", dst: "10.0.0.100", proto: ProtoTCP, payload: tcpHeader(51234, 80)}.bytes()
+	forwardV6 := testIPv6{src: "2001:db8:c::7", dst: "2001:db8:f::100", next: ProtoTCP, payload: tcpHeader(51234, 443)}.bytes()
+
+	// A response from the VIP that a router on the return path could not forward
+	responseV4 := quoted(testIPv4{src: "10.0.0.100", dst: "198.51.100.7", proto: ProtoTCP, payload: concat(tcpHeader(80, 51234), make([]byte, 1400))}.bytes(), ipv4HeaderLen+8)
+	responseV6 := quoted(testIPv6{src: "2001:db8:f::100", dst: "2001:db8:c::7", next: ProtoTCP, payload: concat(tcpHeader(443, 51234), make([]byte, 1400))}.bytes(), 1232)
+	udpResponseV4 := quoted(testIPv4{src: "10.0.0.100", dst: "198.51.100.7", proto: ProtoUDP, payload: udpHeader(53, 40000, make([]byte, 512))}.bytes(), ipv4HeaderLen+8)
+
+	tests := []struct {
+		name     string
+		packet   []byte
+		expected FiveTuple
+		forward  []byte
+	}{
+		{
+			name:     "ICMPv4 fragmentation needed",
+			packet:   testIPv4{src: "203.0.113.1", dst: "10.0.0.100", proto: ProtoICMP, payload: concat(icmpHeader(3, 4, 1400), responseV4)}.bytes(),
+			expected: tuple("198.51.100.7", 51234, "10.0.0.100", 80, ProtoTCP),
+			forward:  forwardV4,
+		},
+		{
+			name:     "ICMPv4 port unreachable for UDP",
+			packet:   testIPv4{src: "203.0.113.1", dst: "10.0.0.100", proto: ProtoICMP, payload: concat(icmpHeader(3, 3, 0), udpResponseV4)}.bytes(),
+			expected: tuple("198.51.100.7", 40000, "10.0.0.100", 53, ProtoUDP),
+		},
+		{
+			name:     "ICMPv4 time exceeded",
+			packet:   testIPv4{src: "203.0.113.1", dst: "10.0.0.100", proto: ProtoICMP, payload: concat(icmpHeader(11, 0, 0), responseV4)}.bytes(),
+			expected: tuple("198.51.100.7", 51234, "10.0.0.100", 80, ProtoTCP),
+			forward:  forwardV4,
+		},
+		{
+			name:     "ICMPv6 packet too big",
+			packet:   testIPv6{src: "2001:db8:e::1", dst: "2001:db8:f::100", next: ProtoICMPv6, payload: concat(icmpHeader(2, 0, 1280), responseV6)}.bytes(),
+			expected: tuple("2001:db8:c::7", 51234, "2001:db8:f::100", 443, ProtoTCP),
+			forward:  forwardV6,
+		},
+		{
+			name:     "ICMPv6 destination unreachable",
+			packet:   testIPv6{src: "2001:db8:e::1", dst: "2001:db8:f::100", next: ProtoICMPv6, payload: concat(icmpHeader(1, 4, 0), responseV6)}.bytes(),
+			expected: tuple("2001:db8:c::7", 51234, "2001:db8:f::100", 443, ProtoTCP),
+			forward:  forwardV6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseIP(tt.packet)
+			assert.NoError(t, err)
+			assert.True(t, IsICMPError(&h))
+
+			inner, err := ParseICMPError(&h)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, inner.FiveTuple)
+
+			if tt.forward != nil {
+				forward, err := ParseIP(tt.forward)
+				assert.NoError(t, err)
+				policy := Policy{FollowICMPErrors: true}
+				assert.Equal(t, policy.Hash64(&forward), policy.Hash64(&h), "the error must follow the flow")
+				assert.NotEqual(t, Policy{}.Hash64(&forward), Policy{}.Hash64(&h))
+			}
+		})
+	}
+
+	t.Run("Not an ICMP error", func(t *testing.T) {
+		tests := [][]byte{
+			testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoICMP, payload: icmpHeader(8, 0, 0)}.bytes(),
+			testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoICMPv6, payload: icmpHeader(128, 0, 0)}.bytes(),
+			testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoICMP, fragment: 10, payload: concat(icmpHeader(3, 4, 0), responseV4)}.bytes(),
+			testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoICMP, fragment: 0x2000, payload: concat(icmpHeader(3, 4, 0), responseV4)}.bytes(),
+			forwardV4,
+		}
+		for _, packet := range tests {
+			h, err := ParseIP(packet)
+			assert.NoError(t, err)
+			assert.False(t, IsICMPError(&h))
+			_, err = ParseICMPError(&h)
+			assert.ErrorIs(t, err, ErrNotICMPError)
+
+			policy := Policy{FollowICMPErrors: true}
+			assert.Equal(t, Policy{}.Hash64(&h), policy.Hash64(&h))
+		}
+	})
+
+	t.Run("Malformed quoted packet", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			packet []byte
+			err    error
+		}{
+			{
+				name:   "Truncated quoted header",
+				packet: testIPv4{src: "203.0.113.1", dst: "10.0.0.100", proto: ProtoICMP, payload: concat(icmpHeader(3, 4, 0), responseV4[:16])}.bytes(),
+				err:    ErrTruncated,
+			},
+			{
+				name:   "Truncated quoted ports",
+				packet: testIPv4{src: "203.0.113.1", dst: "10.0.0.100", proto: ProtoICMP, payload: concat(icmpHeader(3, 4, 0), responseV4[:ipv4HeaderLen+2])}.bytes(),
+				err:    ErrTruncated,
+			},
+			{
+				name:   "IP version mismatch",
+				packet: testIPv4{src: "203.0.113.1", dst: "10.0.0.100", proto: ProtoICMP, payload: concat(icmpHeader(3, 4, 0), responseV6)}.bytes(),
+				err:    ErrMalformed,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				h, err := ParseIP(tt.packet)
+				assert.NoError(t, err)
+				_, err = ParseICMPError(&h)
+				assert.ErrorIs(t, err, tt.err)
+			})
+		}
+	})
+
+	t.Run("Flow label of the quoted packet", func(t *testing.T) {
+		// The backend labels its response independently of the label of the client
+		labeled := quoted(testIPv6{src: "2001:db8:f::100", dst: "2001:db8:c::7", flowLabel: 0xbeef2, next: ProtoTCP, payload: concat(tcpHeader(443, 51234), make([]byte, 1400))}.bytes(), 1232)
+		h, err := ParseIP(testIPv6{src: "2001:db8:e::1", dst: "2001:db8:f::100", next: ProtoICMPv6, payload: concat(icmpHeader(2, 0, 1280), labeled)}.bytes())
+		assert.NoError(t, err)
+
+		inner, err := ParseICMPError(&h)
+		assert.NoError(t, err)
+		assert.Zero(t, inner.FlowLabel)
+
+		// The error follows unlabeled flows, but not labeled ones
+		policy := Policy{Mode: ModeFlowLabel, FollowICMPErrors: true}
+		unlabeledForward, err := ParseIP(forwardV6)
+		assert.NoError(t, err)
+		labeledForward, err := ParseIP(testIPv6{src: "2001:db8:c::7", dst: "2001:db8:f::100", flowLabel: 0xbeef1, next: ProtoTCP, payload: tcpHeader(51234, 443)}.bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, Mode5Tuple, policy.ModeFor(&inner))
+		assert.Equal(t, policy.Hash64(&unlabeledForward), policy.Hash64(&h))
+		assert.NotEqual(t, policy.Hash64(&labeledForward), policy.Hash64(&h))
+	})
+
+	t.Run("Zero allocations", func(t *testing.T) {
+		h, err := ParseIP(testIPv6{src: "2001:db8:e::1", dst: "2001:db8:f::100", next: ProtoICMPv6, payload: concat(icmpHeader(2, 0, 1280), responseV6)}.bytes())
+		assert.NoError(t, err)
+		policy := Policy{FollowICMPErrors: true}
+		allocs := testing.AllocsPerRun(1000, func() {
+			_ = policy.Hash64(&h)
+		})
+		assert.Zero(t, allocs)
+	})
+}
+
+// icmpHeader returns an ICMP header with the given type and code,
+// and the MTU field set for "fragmentation needed" and "packet too big" messages.
+func icmpHeader(typ, code uint8, mtu uint32) []byte {
+	b := make([]byte, icmpHeaderLen)
+	b[0] = typ
+	b[1] = code
+	binary.BigEndian.PutUint32(b[4:], mtu)
+	return b
+}
+
+// quoted truncates the packet to n bytes, as done when quoting it in an ICMP error message.
+func quoted(packet []byte, n int) []byte {
+	return packet[:n]
+}
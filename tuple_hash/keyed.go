@@ -0,0 +1,72 @@
+package tuple_hash
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/dchest/siphash"
+	"net/netip"
+	"sync/atomic"
+)
+
+// HashKeySize is the size of the secret key of KeyedHasher.
+const HashKeySize = 16
+
+var (
+	ErrInvalidHashKey = fmt.Errorf("hash key must be %d bytes", HashKeySize)
+)
+
+// KeyedHasher hashes 5-tuples with SipHash-2-4 keyed by a secret. Its implementation is thread-safe.
+//
+// Unkeyed hashes such as CRC32 let an attacker who knows the algorithm craft flows, e.g. by choosing
+// source ports, that all land on the same backend. Without the key, the hash of a flow cannot be predicted.
+// Use a per-deployment secret shared by all load balancers, so that they agree on the backend of a flow.
+type KeyedHasher struct {
+	key atomic.Pointer[[2]uint64]
+}
+
+// NewKeyedHasher creates a new KeyedHasher with the given HashKeySize bytes key.
+func NewKeyedHasher(key []byte) (*KeyedHasher, error) {
+	k := &KeyedHasher{}
+	if err := k.Rotate(key); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// GenerateHashKey returns a random key for KeyedHasher.
+func GenerateHashKey() ([]byte, error) {
+	key := make([]byte, HashKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Rotate replaces the key. Hashes computed after Rotate returns use the new key.
+// Rotating the key remaps almost every flow, so existing connections move to other backends
+// unless they are tracked by the caller.
+func (k *KeyedHasher) Rotate(key []byte) error {
+	if len(key) != HashKeySize {
+		return ErrInvalidHashKey
+	}
+	k.key.Store(&[2]uint64{
+		binary.LittleEndian.Uint64(key[0:8]),
+		binary.LittleEndian.Uint64(key[8:16]),
+	})
+	return nil
+}
+
+// Hash64 returns the keyed 64-bit hash of the 5-tuple.
+// It serializes the tuple like Hash64, and does not allocate.
+func (k *KeyedHasher) Hash64(srcIP netip.Addr, srcPort uint16, dstIP netip.Addr, dstPort uint16, proto uint8) uint64 {
+	var buf [tupleLen]byte
+	putTuple(&buf, srcIP, srcPort, dstIP, dstPort, proto)
+	return k.Sum64(buf[:])
+}
+
+// Sum64 returns the keyed 64-bit hash of arbitrary data, e.g. a connection ID.
+func (k *KeyedHasher) Sum64(data []byte) uint64 {
+	key := k.key.Load()
+	return siphash.Hash(key[0], key[1], data)
+}
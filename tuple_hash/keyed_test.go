@@ -0,0 +1,167 @@
+package tuple_hash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand/v2"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+func TestKeyedHasher(t *testing.T) {
+	keyA := []byte("0123456789abcdef")
+	keyB := []byte("fedcba9876543210")
+	dst := netip.MustParseAddr("10.0.0.100")
+
+	// randomFlows returns n pseudo-random flows to dst:443
+	randomFlows := func(n int) []FiveTuple {
+		rnd := rand.New(rand.NewPCG(1, 2))
+		flows := make([]FiveTuple, n)
+		for i := range flows {
+			flows[i] = FiveTuple{
+				SrcIP:   netip.AddrFrom4([4]byte{byte(rnd.Uint32()), byte(rnd.Uint32()), byte(rnd.Uint32()), byte(rnd.Uint32())}),
+				DstIP:   dst,
+				SrcPort: uint16(rnd.Uint32()),
+				DstPort: 443,
+				Proto:   ProtoTCP,
+			}
+		}
+		return flows
+	}
+	bucket := func(k *KeyedHasher, f FiveTuple) uint64 {
+		return k.Hash64(f.SrcIP, f.SrcPort, f.DstIP, f.DstPort, f.Proto) % 16
+	}
+
+	t.Run("Different keys produce independent distributions", func(t *testing.T) {
+		a, err := NewKeyedHasher(keyA)
+		assert.NoError(t, err)
+		b, err := NewKeyedHasher(keyB)
+		assert.NoError(t, err)
+
+		const n = 64000
+		var joint [16][16]float64
+		var marginalA, marginalB [16]float64
+		for _, f := range randomFlows(n) {
+			i, j := bucket(a, f), bucket(b, f)
+			joint[i][j]++
+			marginalA[i]++
+			marginalB[j]++
+		}
+
+		// Each key distributes uniformly: chi-square with 15 degrees of freedom, p = 0.001
+		assert.Less(t, chiSquare(marginalA[:], n/16), 37.7)
+		assert.Less(t, chiSquare(marginalB[:], n/16), 37.7)
+
+		// The bucket under one key says nothing about the bucket under the other:
+		// chi-square with 225 degrees of freedom, p = 0.001
+		var cells []float64
+		for i := range joint {
+			cells = append(cells, joint[i][:]...)
+		}
+		assert.Less(t, chiSquare(cells, n/256), 293.0)
+	})
+
+	t.Run("Crafted flows are spread under another key", func(t *testing.T) {
+		a, err := NewKeyedHasher(keyA)
+		assert.NoError(t, err)
+		b, err := NewKeyedHasher(keyB)
+		assert.NoError(t, err)
+
+		// An attacker who knows key A picks the source ports that land on bucket 0
+		src := netip.MustParseAddr("198.51.100.7")
+		var crafted []FiveTuple
+		for port := 0; port <= 0xffff; port++ {
+			f := FiveTuple{SrcIP: src, DstIP: dst, SrcPort: uint16(port), DstPort: 443, Proto: ProtoTCP}
+			if bucket(a, f) == 0 {
+				crafted = append(crafted, f)
+			}
+		}
+
+		var buckets [16]float64
+		for _, f := range crafted {
+			buckets[bucket(b, f)]++
+		}
+		assert.Less(t, chiSquare(buckets[:], float64(len(crafted))/16), 37.7)
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		k, err := NewKeyedHasher(keyA)
+		assert.NoError(t, err)
+		b, err := NewKeyedHasher(keyB)
+		assert.NoError(t, err)
+
+		f := tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP)
+		before := k.Hash64(f.SrcIP, f.SrcPort, f.DstIP, f.DstPort, f.Proto)
+		assert.NoError(t, k.Rotate(keyB))
+		after := k.Hash64(f.SrcIP, f.SrcPort, f.DstIP, f.DstPort, f.Proto)
+		assert.NotEqual(t, before, after)
+		assert.Equal(t, b.Hash64(f.SrcIP, f.SrcPort, f.DstIP, f.DstPort, f.Proto), after)
+
+		assert.ErrorIs(t, k.Rotate(keyA[:8]), ErrInvalidHashKey)
+		assert.Equal(t, after, k.Hash64(f.SrcIP, f.SrcPort, f.DstIP, f.DstPort, f.Proto))
+	})
+
+	t.Run("Concurrent rotation", func(t *testing.T) {
+		k, err := NewKeyedHasher(keyA)
+		assert.NoError(t, err)
+		f := tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					_ = k.Hash64(f.SrcIP, f.SrcPort, f.DstIP, f.DstPort, f.Proto)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					assert.NoError(t, k.Rotate(keyB))
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("Invalid key", func(t *testing.T) {
+		_, err := NewKeyedHasher(nil)
+		assert.ErrorIs(t, err, ErrInvalidHashKey)
+		_, err = NewKeyedHasher(make([]byte, 32))
+		assert.ErrorIs(t, err, ErrInvalidHashKey)
+	})
+
+	t.Run("Generated keys are usable and distinct", func(t *testing.T) {
+		key1, err := GenerateHashKey()
+		assert.NoError(t, err)
+		key2, err := GenerateHashKey()
+		assert.NoError(t, err)
+		assert.NotEqual(t, key1, key2)
+		_, err = NewKeyedHasher(key1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		k, err := NewKeyedHasher(keyA)
+		assert.NoError(t, err)
+		h := Headers{FiveTuple: tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP)}
+		policy := Policy{Key: k}
+		assert.Equal(t, k.Hash64(h.SrcIP, h.SrcPort, h.DstIP, h.DstPort, h.Proto), policy.Hash64(&h))
+		assert.NotEqual(t, Policy{}.Hash64(&h), policy.Hash64(&h))
+
+		allocs := testing.AllocsPerRun(1000, func() {
+			_ = policy.Hash64(&h)
+		})
+		assert.Zero(t, allocs)
+	})
+}
+
+// chiSquare returns the chi-square statistic of the observed counts against a uniform expectation.
+func chiSquare(observed []float64, expected float64) float64 {
+	var sum float64
+	for _, o := range observed {
+		sum += (o - expected) * (o - expected) / expected
+	}
+	return sum
+}
@@ -0,0 +1,184 @@
+package tuple_hash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+)
+
+// HashMode selects the fields of a packet that are hashed.
+type HashMode int
+
+const (
+	// Mode5Tuple hashes source IP, source port, destination IP, destination port and protocol.
+	Mode5Tuple HashMode = iota
+	// Mode3Tuple hashes source IP, destination IP and protocol.
+	Mode3Tuple
+	// Mode2Tuple hashes source IP and destination IP.
+	Mode2Tuple
+	// ModeFlowLabel hashes source IP, destination IP and the IPv6 flow label, as recommended by RFC 6438.
+	// The flow label is present in every fragment and does not depend on the transport protocol,
+	// so it also covers fragments and encrypted transports.
+	// Packets without a flow label, including IPv4 packets, are hashed by Mode5Tuple.
+	ModeFlowLabel
+)
+
+var (
+	ErrUnknownHashMode = fmt.Errorf("unknown hash mode")
+)
+
+var hashModeNames = map[HashMode]string{
+	Mode5Tuple:    "5-tuple",
+	Mode3Tuple:    "3-tuple",
+	Mode2Tuple:    "2-tuple",
+	ModeFlowLabel: "flow-label",
+}
+
+// ParseHashMode parses the name of a hash mode, e.g. "5-tuple".
+func ParseHashMode(s string) (HashMode, error) {
+	for mode, name := range hashModeNames {
+		if name == s {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownHashMode, s)
+}
+
+func (m HashMode) String() string {
+	if name, ok := hashModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("HashMode(%d)", int(m))
+}
+
+func (m HashMode) MarshalText() ([]byte, error) {
+	if _, ok := hashModeNames[m]; !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownHashMode, int(m))
+	}
+	return []byte(m.String()), nil
+}
+
+func (m *HashMode) UnmarshalText(text []byte) error {
+	mode, err := ParseHashMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+// usesPorts returns true if the mode hashes L4 ports.
+func (m HashMode) usesPorts() bool {
+	return m == Mode5Tuple
+}
+
+// Hash64 returns the 64-bit hash of the fields of h selected by the mode.
+// It does not allocate.
+func (m HashMode) Hash64(h *Headers) uint64 {
+	return m.hash64(nil, h)
+}
+
+// hash64 is like Hash64, but keyed by key if it is not nil.
+func (m HashMode) hash64(key *KeyedHasher, h *Headers) uint64 {
+	hash := Hash64
+	if key != nil {
+		hash = key.Hash64
+	}
+	switch m {
+	case Mode3Tuple:
+		return hash(h.SrcIP, 0, h.DstIP, 0, h.Proto)
+	case Mode2Tuple:
+		return hash(h.SrcIP, 0, h.DstIP, 0, 0)
+	case ModeFlowLabel:
+		if h.FlowLabel == 0 {
+			return Mode5Tuple.hash64(key, h)
+		}
+		return hashFlowLabel(key, h)
+	default:
+		return hash(h.SrcIP, h.SrcPort, h.DstIP, h.DstPort, h.Proto)
+	}
+}
+
+// hashFlowLabel returns the hash of the source IP, destination IP and flow label of h.
+func hashFlowLabel(key *KeyedHasher, h *Headers) uint64 {
+	var buf [16 + 16 + 3]byte
+	src, dst := h.SrcIP.As16(), h.DstIP.As16()
+	copy(buf[0:16], src[:])
+	copy(buf[16:32], dst[:])
+	var label [4]byte
+	binary.BigEndian.PutUint32(label[:], h.FlowLabel)
+	copy(buf[32:35], label[1:])
+	if key != nil {
+		return key.Sum64(buf[:])
+	}
+	return crc64.Checksum(buf[:], crc64Table)
+}
+
+// Policy selects the hash mode per packet.
+//
+// Non-first IP fragments carry no L4 header, so hashing them by ports would send them to
+// a different backend than the first fragment. Like Maglev, the policy hashes every fragment,
+// including the first one, without ports, so that all fragments of a packet land on the same backend.
+//
+// With ModeFlowLabel, fragments with a flow label are hashed by it, as it is present in every fragment.
+//
+// The zero value hashes non-fragmented packets by 5-tuple and fragments by 3-tuple.
+type Policy struct {
+	// Mode is the hash mode for non-fragmented packets.
+	Mode HashMode `mapstructure:"mode"`
+	// FragmentMode is the hash mode for fragmented packets without a flow label.
+	// Modes that use ports are replaced by Mode3Tuple.
+	FragmentMode HashMode `mapstructure:"fragment_mode"`
+	// FollowICMPErrors hashes ICMP error messages by the reversed flow of the packet they quote,
+	// so that errors such as "fragmentation needed" reach the backend serving the flow.
+	// The flow label of the client is unknown to the error, so with ModeFlowLabel, errors only follow
+	// flows without a flow label. See ParseICMPError.
+	FollowICMPErrors bool `mapstructure:"follow_icmp_errors"`
+	// Decap hashes tunneled packets by their inner packet. See Decap.
+	Decap Decap `mapstructure:"decap"`
+	// Key keys the hash against hash-flooding if it is not nil. See KeyedHasher.
+	Key *KeyedHasher `mapstructure:"-"`
+}
+
+// ModeFor returns the hash mode for the packet.
+func (p Policy) ModeFor(h *Headers) HashMode {
+	if p.Mode == ModeFlowLabel && h.FlowLabel != 0 {
+		return ModeFlowLabel
+	}
+
+	mode := p.Mode
+	if h.IsFragment() {
+		mode = p.FragmentMode
+	}
+	if mode == ModeFlowLabel && h.FlowLabel == 0 {
+		mode = Mode5Tuple
+	}
+	if h.IsFragment() && mode.usesPorts() {
+		return Mode3Tuple
+	}
+	return mode
+}
+
+// Flow returns the headers the packet is hashed by: the inner packet of tunneled packets,
+// and the reversed quoted packet of ICMP errors, if enabled by the policy. Otherwise, the packet itself.
+func (p Policy) Flow(h *Headers) Headers {
+	flow := *h
+	if p.Decap.MaxDepth > 0 {
+		// Packets with a malformed inner packet are hashed by the last parsed layer
+		flow, _, _ = p.Decap.Decapsulate(flow)
+	}
+	if p.FollowICMPErrors {
+		// Errors quoting a malformed packet are hashed as they are
+		if inner, err := ParseICMPError(&flow); err == nil {
+			flow = inner
+		}
+	}
+	return flow
+}
+
+// Hash64 returns the 64-bit hash of the packet according to the policy.
+// It does not allocate.
+func (p Policy) Hash64(h *Headers) uint64 {
+	flow := p.Flow(h)
+	return p.ModeFor(&flow).hash64(p.Key, &flow)
+}
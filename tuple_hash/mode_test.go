@@ -0,0 +1,157 @@
+package tuple_hash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHashMode(t *testing.T) {
+	h := Headers{FiveTuple: tuple("10.0.0.1", 51234, "10.0.0.2", 443, ProtoTCP)}
+	otherPorts := Headers{FiveTuple: tuple("10.0.0.1", 40000, "10.0.0.2", 80, ProtoTCP)}
+	otherProto := Headers{FiveTuple: tuple("10.0.0.1", 51234, "10.0.0.2", 443, ProtoUDP)}
+
+	t.Run("5-tuple", func(t *testing.T) {
+		assert.Equal(t, Hash64(h.SrcIP, h.SrcPort, h.DstIP, h.DstPort, h.Proto), Mode5Tuple.Hash64(&h))
+		assert.NotEqual(t, Mode5Tuple.Hash64(&h), Mode5Tuple.Hash64(&otherPorts))
+		assert.NotEqual(t, Mode5Tuple.Hash64(&h), Mode5Tuple.Hash64(&otherProto))
+	})
+
+	t.Run("3-tuple", func(t *testing.T) {
+		assert.Equal(t, Mode3Tuple.Hash64(&h), Mode3Tuple.Hash64(&otherPorts))
+		assert.NotEqual(t, Mode3Tuple.Hash64(&h), Mode3Tuple.Hash64(&otherProto))
+	})
+
+	t.Run("2-tuple", func(t *testing.T) {
+		assert.Equal(t, Mode2Tuple.Hash64(&h), Mode2Tuple.Hash64(&otherPorts))
+		assert.Equal(t, Mode2Tuple.Hash64(&h), Mode2Tuple.Hash64(&otherProto))
+	})
+
+	t.Run("Text round trip", func(t *testing.T) {
+		for _, mode := range []HashMode{Mode5Tuple, Mode3Tuple, Mode2Tuple, ModeFlowLabel} {
+			text, err := mode.MarshalText()
+			assert.NoError(t, err)
+			var parsed HashMode
+			assert.NoError(t, parsed.UnmarshalText(text))
+			assert.Equal(t, mode, parsed)
+		}
+
+		_, err := ParseHashMode("4-tuple")
+		assert.ErrorIs(t, err, ErrUnknownHashMode)
+		_, err = HashMode(42).MarshalText()
+		assert.ErrorIs(t, err, ErrUnknownHashMode)
+	})
+}
+
+func TestPolicy(t *testing.T) {
+	udp := udpHeader(5353, 53, make([]byte, 16))
+	first, err := ParseIP(testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoUDP, fragment: 0x2000, payload: udp}.bytes())
+	assert.NoError(t, err)
+	last, err := ParseIP(testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoUDP, fragment: 3, payload: []byte("rest")}.bytes())
+	assert.NoError(t, err)
+	whole, err := ParseIP(testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoUDP, payload: udp}.bytes())
+	assert.NoError(t, err)
+	v6first, err := ParseIP(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoUDP, 0, true), udp)}.bytes())
+	assert.NoError(t, err)
+	v6last, err := ParseIP(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoUDP, 24, false), []byte("rest"))}.bytes())
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		policy       Policy
+		fragmentMode HashMode
+	}{
+		{name: "Zero value", policy: Policy{}, fragmentMode: Mode3Tuple},
+		{name: "3-tuple fragments", policy: Policy{Mode: Mode5Tuple, FragmentMode: Mode3Tuple}, fragmentMode: Mode3Tuple},
+		{name: "2-tuple fragments", policy: Policy{Mode: Mode5Tuple, FragmentMode: Mode2Tuple}, fragmentMode: Mode2Tuple},
+		{name: "Ports are never used for fragments", policy: Policy{Mode: Mode5Tuple, FragmentMode: Mode5Tuple}, fragmentMode: Mode3Tuple},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.policy.Mode, tt.policy.ModeFor(&whole))
+			assert.Equal(t, tt.fragmentMode, tt.policy.ModeFor(&first))
+			assert.Equal(t, tt.fragmentMode, tt.policy.ModeFor(&last))
+
+			// All fragments of a packet hash the same
+			assert.Equal(t, tt.policy.Hash64(&first), tt.policy.Hash64(&last))
+			assert.Equal(t, tt.policy.Hash64(&v6first), tt.policy.Hash64(&v6last))
+			assert.Equal(t, tt.policy.Mode.Hash64(&whole), tt.policy.Hash64(&whole))
+		})
+	}
+}
+
+func TestFlowLabel(t *testing.T) {
+	udp := udpHeader(5353, 53, make([]byte, 16))
+	tcp := tcpHeader(51234, 443)
+	parse := func(packet []byte) Headers {
+		h, err := ParseIP(packet)
+		assert.NoError(t, err)
+		return h
+	}
+
+	labeled := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", flowLabel: 0xbeef1, next: ProtoTCP, payload: tcp}.bytes())
+	otherPorts := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", flowLabel: 0xbeef1, next: ProtoTCP, payload: tcpHeader(40000, 443)}.bytes())
+	otherLabel := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", flowLabel: 0xbeef2, next: ProtoTCP, payload: tcp}.bytes())
+	esp := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", flowLabel: 0xbeef1, next: ProtoESP, payload: []byte{0, 0, 0, 1, 0, 0, 0, 1}}.bytes())
+	unlabeled := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoTCP, payload: tcp}.bytes())
+	v4 := parse(testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoTCP, payload: tcp}.bytes())
+	labeledFirst := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", flowLabel: 0xbeef1, next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoUDP, 0, true), udp)}.bytes())
+	labeledLast := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", flowLabel: 0xbeef1, next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoUDP, 24, false), []byte("rest"))}.bytes())
+	unlabeledFirst := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoUDP, 0, true), udp)}.bytes())
+	unlabeledLast := parse(testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoUDP, 24, false), []byte("rest"))}.bytes())
+
+	t.Run("Mode", func(t *testing.T) {
+		assert.Equal(t, ModeFlowLabel.Hash64(&labeled), ModeFlowLabel.Hash64(&otherPorts))
+		assert.Equal(t, ModeFlowLabel.Hash64(&labeled), ModeFlowLabel.Hash64(&esp))
+		assert.NotEqual(t, ModeFlowLabel.Hash64(&labeled), ModeFlowLabel.Hash64(&otherLabel))
+		assert.NotEqual(t, ModeFlowLabel.Hash64(&labeled), Mode5Tuple.Hash64(&labeled))
+
+		// Fallback to 5-tuple without a flow label
+		assert.Equal(t, Mode5Tuple.Hash64(&unlabeled), ModeFlowLabel.Hash64(&unlabeled))
+		assert.Equal(t, Mode5Tuple.Hash64(&v4), ModeFlowLabel.Hash64(&v4))
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		policy := Policy{Mode: ModeFlowLabel}
+		tests := []struct {
+			name     string
+			packet   Headers
+			expected HashMode
+		}{
+			{name: "Labeled", packet: labeled, expected: ModeFlowLabel},
+			{name: "Labeled first fragment", packet: labeledFirst, expected: ModeFlowLabel},
+			{name: "Labeled non-first fragment", packet: labeledLast, expected: ModeFlowLabel},
+			{name: "Unlabeled", packet: unlabeled, expected: Mode5Tuple},
+			{name: "Unlabeled fragment", packet: unlabeledLast, expected: Mode3Tuple},
+			{name: "IPv4", packet: v4, expected: Mode5Tuple},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, tt.expected, policy.ModeFor(&tt.packet))
+			})
+		}
+
+		assert.Equal(t, policy.Hash64(&labeledFirst), policy.Hash64(&labeledLast))
+		assert.Equal(t, policy.Hash64(&unlabeledFirst), policy.Hash64(&unlabeledLast))
+		assert.Equal(t, policy.Hash64(&labeled), policy.Hash64(&esp))
+
+		// Flow label as the fragment mode only
+		fragments := Policy{Mode: Mode5Tuple, FragmentMode: ModeFlowLabel}
+		assert.Equal(t, Mode5Tuple, fragments.ModeFor(&labeled))
+		assert.Equal(t, ModeFlowLabel, fragments.ModeFor(&labeledLast))
+		assert.Equal(t, Mode3Tuple, fragments.ModeFor(&unlabeledLast))
+	})
+
+	t.Run("Keyed", func(t *testing.T) {
+		key, err := NewKeyedHasher([]byte("0123456789abcdef"))
+		assert.NoError(t, err)
+		policy := Policy{Mode: ModeFlowLabel, Key: key}
+		assert.NotEqual(t, Policy{Mode: ModeFlowLabel}.Hash64(&labeled), policy.Hash64(&labeled))
+		assert.Equal(t, policy.Hash64(&labeled), policy.Hash64(&otherPorts))
+
+		allocs := testing.AllocsPerRun(1000, func() {
+			_ = policy.Hash64(&labeled)
+		})
+		assert.Zero(t, allocs)
+	})
+}
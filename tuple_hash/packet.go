@@ -0,0 +1,311 @@
+package tuple_hash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// EtherTypes recognized by ParseEthernet.
+const (
+	EtherTypeIPv4 uint16 = 0x0800
+	EtherTypeIPv6 uint16 = 0x86dd
+	EtherTypeVLAN uint16 = 0x8100
+	EtherTypeQinQ uint16 = 0x88a8
+)
+
+// IP protocol numbers recognized by the parser.
+const (
+	ProtoHopByHop uint8 = 0
+	ProtoICMP     uint8 = 1
+	ProtoTCP      uint8 = 6
+	ProtoUDP      uint8 = 17
+	ProtoRouting  uint8 = 43
+	ProtoFragment uint8 = 44
+	ProtoESP      uint8 = 50
+	ProtoAH       uint8 = 51
+	ProtoICMPv6   uint8 = 58
+	ProtoNoNext   uint8 = 59
+	ProtoDstOpts  uint8 = 60
+	ProtoSCTP     uint8 = 132
+	ProtoMobility uint8 = 135
+	ProtoHIP      uint8 = 139
+	ProtoShim6    uint8 = 140
+)
+
+const (
+	ethernetHdrLen = 14
+	vlanTagLen     = 4
+	ipv4HeaderLen  = 20
+	ipv6HeaderLen  = 40
+)
+
+var (
+	ErrTruncated   = fmt.Errorf("truncated packet")
+	ErrMalformed   = fmt.Errorf("malformed packet")
+	ErrUnsupported = fmt.Errorf("unsupported packet")
+)
+
+// ParseError describes where parsing a packet failed.
+// Err is one of ErrTruncated, ErrMalformed or ErrUnsupported, use errors.Is to check it.
+type ParseError struct {
+	// Layer is the header being parsed, e.g. "ipv4" or "tcp".
+	Layer string
+	// Offset is the offset of the header in the parsed buffer.
+	Offset int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s header at offset %d: %v", e.Layer, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Headers is the result of parsing a packet.
+// Slices reference the parsed buffer, no data is copied.
+type Headers struct {
+	FiveTuple
+	// IPVersion is 4 or 6.
+	IPVersion uint8
+	// VLANs is the number of 802.1Q/802.1ad tags, VLANID is the ID of the innermost tag.
+	VLANs  int
+	VLANID uint16
+	// FlowLabel is the IPv6 flow label. Zero for IPv4.
+	FlowLabel uint32
+	// FragOffset is the fragment offset in bytes, MoreFragments is the MF flag.
+	// For IPv6, these are taken from the fragment extension header.
+	FragOffset    uint16
+	MoreFragments bool
+	// Network is the IP header, including IPv4 options and IPv6 extension headers.
+	Network []byte
+	// Transport is the transport header and payload, up to the end of the IP packet.
+	// For non-first fragments, it is the fragment data and ports are not parsed.
+	Transport []byte
+}
+
+// IsFragment returns true if the packet is an IP fragment, including the first fragment.
+func (h *Headers) IsFragment() bool {
+	return h.MoreFragments || h.FragOffset != 0
+}
+
+// ParseEthernet parses an Ethernet frame with optional 802.1Q/802.1ad tags carrying IPv4 or IPv6.
+// It does not allocate unless it returns an error.
+func ParseEthernet(data []byte) (Headers, error) {
+	var h Headers
+	if err := parseEthernet(data, 0, &h); err != nil {
+		return Headers{}, err
+	}
+	return h, nil
+}
+
+// parseEthernet parses the Ethernet frame starting at data[offset:] into h.
+func parseEthernet(data []byte, offset int, h *Headers) error {
+	if len(data) < offset+ethernetHdrLen {
+		return &ParseError{Layer: "ethernet", Offset: offset, Err: ErrTruncated}
+	}
+
+	start := offset
+	offset += 12
+	etherType := binary.BigEndian.Uint16(data[offset:])
+	offset += 2
+	for etherType == EtherTypeVLAN || etherType == EtherTypeQinQ {
+		if len(data) < offset+vlanTagLen {
+			return &ParseError{Layer: "vlan", Offset: offset, Err: ErrTruncated}
+		}
+		h.VLANs++
+		h.VLANID = binary.BigEndian.Uint16(data[offset:]) & 0x0fff
+		etherType = binary.BigEndian.Uint16(data[offset+2:])
+		offset += vlanTagLen
+	}
+
+	switch etherType {
+	case EtherTypeIPv4, EtherTypeIPv6:
+		return parseIP(data, offset, h, false)
+	default:
+		return &ParseError{Layer: "ethernet", Offset: start, Err: ErrUnsupported}
+	}
+}
+
+// ParseIP parses an IPv4 or IPv6 packet, starting at the IP header.
+// It does not allocate unless it returns an error.
+func ParseIP(data []byte) (Headers, error) {
+	var h Headers
+	if err := parseIP(data, 0, &h, false); err != nil {
+		return Headers{}, err
+	}
+	return h, nil
+}
+
+// parseIP parses the IP packet starting at data[offset:] into h.
+// If quoted is true, the packet is one quoted in an ICMP error message, which may be
+// truncated after the first 8 bytes of the transport header.
+func parseIP(data []byte, offset int, h *Headers, quoted bool) error {
+	if len(data) <= offset {
+		return &ParseError{Layer: "ip", Offset: offset, Err: ErrTruncated}
+	}
+	switch data[offset] >> 4 {
+	case 4:
+		return parseIPv4(data, offset, h, quoted)
+	case 6:
+		return parseIPv6(data, offset, h, quoted)
+	default:
+		return &ParseError{Layer: "ip", Offset: offset, Err: ErrUnsupported}
+	}
+}
+
+func parseIPv4(data []byte, offset int, h *Headers, quoted bool) error {
+	if len(data) < offset+ipv4HeaderLen {
+		return &ParseError{Layer: "ipv4", Offset: offset, Err: ErrTruncated}
+	}
+	ip := data[offset:]
+
+	headerLen := int(ip[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(ip[2:]))
+	if headerLen < ipv4HeaderLen || totalLen < headerLen {
+		return &ParseError{Layer: "ipv4", Offset: offset, Err: ErrMalformed}
+	}
+	if len(ip) < totalLen {
+		if !quoted || len(ip) < headerLen {
+			return &ParseError{Layer: "ipv4", Offset: offset, Err: ErrTruncated}
+		}
+		totalLen = len(ip)
+	}
+	// Drop link layer padding
+	ip = ip[:totalLen]
+
+	flagsAndOffset := binary.BigEndian.Uint16(ip[6:])
+	h.IPVersion = 4
+	h.MoreFragments = flagsAndOffset&0x2000 != 0
+	h.FragOffset = (flagsAndOffset & 0x1fff) * 8
+	h.Proto = ip[9]
+	h.SrcIP = netip.AddrFrom4([4]byte(ip[12:16]))
+	h.DstIP = netip.AddrFrom4([4]byte(ip[16:20]))
+	h.Network = ip[:headerLen]
+	h.Transport = ip[headerLen:]
+
+	if h.FragOffset != 0 {
+		return nil
+	}
+	return parseTransport(offset+headerLen, h, quoted)
+}
+
+func parseIPv6(data []byte, offset int, h *Headers, quoted bool) error {
+	if len(data) < offset+ipv6HeaderLen {
+		return &ParseError{Layer: "ipv6", Offset: offset, Err: ErrTruncated}
+	}
+	ip := data[offset:]
+
+	payloadLen := int(binary.BigEndian.Uint16(ip[4:]))
+	// A zero payload length is used by jumbograms, take the rest of the buffer
+	if payloadLen != 0 {
+		if len(ip) < ipv6HeaderLen+payloadLen {
+			if !quoted {
+				return &ParseError{Layer: "ipv6", Offset: offset, Err: ErrTruncated}
+			}
+			payloadLen = len(ip) - ipv6HeaderLen
+		}
+		// Drop link layer padding
+		ip = ip[:ipv6HeaderLen+payloadLen]
+	}
+
+	h.IPVersion = 6
+	h.FlowLabel = binary.BigEndian.Uint32(ip[0:]) & 0x000fffff
+	h.SrcIP = netip.AddrFrom16([16]byte(ip[8:24]))
+	h.DstIP = netip.AddrFrom16([16]byte(ip[24:40]))
+
+	// Walk the extension headers
+	next := ip[6]
+	headerLen := ipv6HeaderLen
+	for {
+		extOffset := offset + headerLen
+		switch next {
+		case ProtoHopByHop, ProtoRouting, ProtoDstOpts, ProtoMobility, ProtoHIP, ProtoShim6:
+			if len(ip) < headerLen+8 {
+				return &ParseError{Layer: "ipv6-ext", Offset: extOffset, Err: ErrTruncated}
+			}
+			extLen := (int(ip[headerLen+1]) + 1) * 8
+			if len(ip) < headerLen+extLen {
+				return &ParseError{Layer: "ipv6-ext", Offset: extOffset, Err: ErrTruncated}
+			}
+			next = ip[headerLen]
+			headerLen += extLen
+		case ProtoAH:
+			if len(ip) < headerLen+8 {
+				return &ParseError{Layer: "ipv6-ah", Offset: extOffset, Err: ErrTruncated}
+			}
+			extLen := (int(ip[headerLen+1]) + 2) * 4
+			if len(ip) < headerLen+extLen {
+				return &ParseError{Layer: "ipv6-ah", Offset: extOffset, Err: ErrTruncated}
+			}
+			next = ip[headerLen]
+			headerLen += extLen
+		case ProtoFragment:
+			if len(ip) < headerLen+8 {
+				return &ParseError{Layer: "ipv6-frag", Offset: extOffset, Err: ErrTruncated}
+			}
+			flagsAndOffset := binary.BigEndian.Uint16(ip[headerLen+2:])
+			h.MoreFragments = flagsAndOffset&0x0001 != 0
+			h.FragOffset = flagsAndOffset &^ 0x0007
+			next = ip[headerLen]
+			headerLen += 8
+			// Headers following the fragment header are only present in the first fragment
+			if h.FragOffset != 0 {
+				h.Proto = next
+				h.Network = ip[:headerLen]
+				h.Transport = ip[headerLen:]
+				return nil
+			}
+		default:
+			h.Proto = next
+			h.Network = ip[:headerLen]
+			h.Transport = ip[headerLen:]
+			return parseTransport(offset+headerLen, h, quoted)
+		}
+	}
+}
+
+// parseTransport parses the ports of h.Transport, which is at the given offset of the parsed buffer.
+// If quoted is true, only the ports are required to be present.
+func parseTransport(offset int, h *Headers, quoted bool) error {
+	l4 := h.Transport
+	if quoted {
+		switch h.Proto {
+		case ProtoTCP, ProtoUDP, ProtoSCTP:
+			if len(l4) < 4 {
+				return &ParseError{Layer: "ports", Offset: offset, Err: ErrTruncated}
+			}
+			h.SrcPort = binary.BigEndian.Uint16(l4[0:])
+			h.DstPort = binary.BigEndian.Uint16(l4[2:])
+		}
+		return nil
+	}
+	switch h.Proto {
+	case ProtoTCP:
+		if len(l4) < 20 {
+			return &ParseError{Layer: "tcp", Offset: offset, Err: ErrTruncated}
+		}
+		dataOffset := int(l4[12]>>4) * 4
+		if dataOffset < 20 {
+			return &ParseError{Layer: "tcp", Offset: offset, Err: ErrMalformed}
+		}
+		if len(l4) < dataOffset {
+			return &ParseError{Layer: "tcp", Offset: offset, Err: ErrTruncated}
+		}
+	case ProtoUDP:
+		if len(l4) < 8 {
+			return &ParseError{Layer: "udp", Offset: offset, Err: ErrTruncated}
+		}
+	case ProtoSCTP:
+		if len(l4) < 12 {
+			return &ParseError{Layer: "sctp", Offset: offset, Err: ErrTruncated}
+		}
+	default:
+		return nil
+	}
+	h.SrcPort = binary.BigEndian.Uint16(l4[0:])
+	h.DstPort = binary.BigEndian.Uint16(l4[2:])
+	return nil
+}
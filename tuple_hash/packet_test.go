@@ -0,0 +1,391 @@
+package tuple_hash
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/netip"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tcp := tcpHeader(51234, 443)
+	udp := udpHeader(5353, 53, []byte("query"))
+	sctp := sctpHeader(36412, 38412)
+
+	tests := []struct {
+		name     string
+		packet   []byte
+		ethernet bool
+		expected Headers
+	}{
+		{
+			name:     "Ethernet IPv4 TCP",
+			packet:   ethernet(EtherTypeIPv4, nil, testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoTCP, payload: tcp}.bytes()),
+			ethernet: true,
+			expected: Headers{
+				FiveTuple: tuple("10.0.0.1", 51234, "10.0.0.2", 443, ProtoTCP),
+				IPVersion: 4,
+			},
+		},
+		{
+			name:     "Ethernet 802.1Q IPv4 UDP",
+			packet:   ethernet(EtherTypeIPv4, []uint16{100}, testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoUDP, payload: udp}.bytes()),
+			ethernet: true,
+			expected: Headers{
+				FiveTuple: tuple("10.0.0.1", 5353, "10.0.0.2", 53, ProtoUDP),
+				IPVersion: 4,
+				VLANs:     1,
+				VLANID:    100,
+			},
+		},
+		{
+			name:     "Ethernet QinQ IPv6 SCTP",
+			packet:   ethernet(EtherTypeIPv6, []uint16{200, 300}, testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoSCTP, payload: sctp}.bytes()),
+			ethernet: true,
+			expected: Headers{
+				FiveTuple: tuple("2001:db8::1", 36412, "2001:db8::2", 38412, ProtoSCTP),
+				IPVersion: 6,
+				VLANs:     2,
+				VLANID:    300,
+			},
+		},
+		{
+			name:   "IPv4 with options",
+			packet: testIPv4{src: "192.168.0.1", dst: "192.168.0.2", proto: ProtoTCP, options: []byte{0x94, 0x04, 0x00, 0x00, 0x01, 0x01, 0x01, 0x00}, payload: tcp}.bytes(),
+			expected: Headers{
+				FiveTuple: tuple("192.168.0.1", 51234, "192.168.0.2", 443, ProtoTCP),
+				IPVersion: 4,
+			},
+		},
+		{
+			name:   "IPv4 first fragment",
+			packet: testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoUDP, fragment: 0x2000, payload: udp}.bytes(),
+			expected: Headers{
+				FiveTuple:     tuple("10.0.0.1", 5353, "10.0.0.2", 53, ProtoUDP),
+				IPVersion:     4,
+				MoreFragments: true,
+			},
+		},
+		{
+			name:   "IPv4 non-first fragment",
+			packet: testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoUDP, fragment: 185, payload: []byte("fragment data")}.bytes(),
+			expected: Headers{
+				FiveTuple:  tuple("10.0.0.1", 0, "10.0.0.2", 0, ProtoUDP),
+				IPVersion:  4,
+				FragOffset: 1480,
+			},
+		},
+		{
+			name: "IPv6 with extension headers",
+			packet: testIPv6{
+				src: "2001:db8::1", dst: "2001:db8::2", flowLabel: 0x12345, next: ProtoHopByHop,
+				payload: concat(
+					ipv6ExtHeader(ProtoDstOpts, 0),
+					ipv6ExtHeader(ProtoRouting, 1),
+					ipv6ExtHeader(ProtoTCP, 0),
+					tcp,
+				),
+			}.bytes(),
+			expected: Headers{
+				FiveTuple: tuple("2001:db8::1", 51234, "2001:db8::2", 443, ProtoTCP),
+				IPVersion: 6,
+				FlowLabel: 0x12345,
+			},
+		},
+		{
+			name:   "IPv6 first fragment",
+			packet: testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoUDP, 0, true), udp)}.bytes(),
+			expected: Headers{
+				FiveTuple:     tuple("2001:db8::1", 5353, "2001:db8::2", 53, ProtoUDP),
+				IPVersion:     6,
+				MoreFragments: true,
+			},
+		},
+		{
+			name:   "IPv6 non-first fragment",
+			packet: testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoUDP, 1232, false), []byte("fragment data"))}.bytes(),
+			expected: Headers{
+				FiveTuple:  tuple("2001:db8::1", 0, "2001:db8::2", 0, ProtoUDP),
+				IPVersion:  6,
+				FragOffset: 1232,
+			},
+		},
+		{
+			name:   "IPv6 ESP has no ports",
+			packet: testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoESP, payload: []byte{0, 0, 0, 1, 0, 0, 0, 1}}.bytes(),
+			expected: Headers{
+				FiveTuple: tuple("2001:db8::1", 0, "2001:db8::2", 0, ProtoESP),
+				IPVersion: 6,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				h   Headers
+				err error
+			)
+			if tt.ethernet {
+				h, err = ParseEthernet(tt.packet)
+			} else {
+				h, err = ParseIP(tt.packet)
+			}
+			assert.NoError(t, err)
+
+			assert.Equal(t, tt.expected.FiveTuple, h.FiveTuple)
+			assert.Equal(t, tt.expected.IPVersion, h.IPVersion)
+			assert.Equal(t, tt.expected.VLANs, h.VLANs)
+			assert.Equal(t, tt.expected.VLANID, h.VLANID)
+			assert.Equal(t, tt.expected.FlowLabel, h.FlowLabel)
+			assert.Equal(t, tt.expected.FragOffset, h.FragOffset)
+			assert.Equal(t, tt.expected.MoreFragments, h.MoreFragments)
+			assert.Equal(t, tt.expected.MoreFragments || tt.expected.FragOffset != 0, h.IsFragment())
+		})
+	}
+
+	t.Run("Link layer padding is dropped", func(t *testing.T) {
+		packet := testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoTCP, payload: tcp}.bytes()
+		h, err := ParseIP(append(packet, 0, 0, 0, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, tcp, h.Transport)
+		assert.Len(t, h.Network, ipv4HeaderLen)
+	})
+
+	t.Run("Zero copy", func(t *testing.T) {
+		packet := testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoTCP, payload: tcp}.bytes()
+		h, err := ParseIP(packet)
+		assert.NoError(t, err)
+		assert.Same(t, &packet[ipv4HeaderLen], &h.Transport[0])
+	})
+
+	t.Run("Zero allocations", func(t *testing.T) {
+		packet := ethernet(EtherTypeIPv6, []uint16{1}, testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoTCP, payload: tcp}.bytes())
+		allocs := testing.AllocsPerRun(1000, func() {
+			_, _ = ParseEthernet(packet)
+		})
+		assert.Zero(t, allocs)
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	tcp := tcpHeader(51234, 443)
+	v4 := testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoTCP, payload: tcp}.bytes()
+	v6 := testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoTCP, payload: tcp}.bytes()
+
+	badIHL := append([]byte(nil), v4...)
+	badIHL[0] = 0x44
+	badTotalLen := append([]byte(nil), v4...)
+	binary.BigEndian.PutUint16(badTotalLen[2:], 10)
+	badDataOffset := testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoTCP, payload: append([]byte(nil), tcp...)}.bytes()
+	badDataOffset[ipv4HeaderLen+12] = 0x40
+	longExt := testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoHopByHop, payload: ipv6ExtHeader(ProtoTCP, 4)[:8]}.bytes()
+
+	tests := []struct {
+		name     string
+		packet   []byte
+		ethernet bool
+		layer    string
+		err      error
+	}{
+		{name: "Short Ethernet", packet: make([]byte, 10), ethernet: true, layer: "ethernet", err: ErrTruncated},
+		{name: "Truncated VLAN tag", packet: ethernet(EtherTypeVLAN, nil, []byte{0, 1}), ethernet: true, layer: "vlan", err: ErrTruncated},
+		{name: "ARP", packet: ethernet(0x0806, nil, make([]byte, 28)), ethernet: true, layer: "ethernet", err: ErrUnsupported},
+		{name: "Empty IP", packet: nil, layer: "ip", err: ErrTruncated},
+		{name: "Unknown IP version", packet: []byte{0x50, 0, 0, 0}, layer: "ip", err: ErrUnsupported},
+		{name: "Short IPv4", packet: v4[:19], layer: "ipv4", err: ErrTruncated},
+		{name: "IPv4 header length below minimum", packet: badIHL, layer: "ipv4", err: ErrMalformed},
+		{name: "IPv4 total length below header length", packet: badTotalLen, layer: "ipv4", err: ErrMalformed},
+		{name: "IPv4 total length beyond buffer", packet: v4[:len(v4)-1], layer: "ipv4", err: ErrTruncated},
+		{name: "TCP data offset below minimum", packet: badDataOffset, layer: "tcp", err: ErrMalformed},
+		{name: "Short IPv6", packet: v6[:39], layer: "ipv6", err: ErrTruncated},
+		{name: "IPv6 payload length beyond buffer", packet: v6[:len(v6)-1], layer: "ipv6", err: ErrTruncated},
+		{name: "IPv6 extension header beyond payload", packet: longExt, layer: "ipv6-ext", err: ErrTruncated},
+		{name: "Truncated UDP", packet: testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoUDP, payload: make([]byte, 7)}.bytes(), layer: "udp", err: ErrTruncated},
+		{name: "Truncated SCTP", packet: testIPv6{src: "2001:db8::1", dst: "2001:db8::2", next: ProtoSCTP, payload: make([]byte, 11)}.bytes(), layer: "sctp", err: ErrTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if tt.ethernet {
+				_, err = ParseEthernet(tt.packet)
+			} else {
+				_, err = ParseIP(tt.packet)
+			}
+			assert.ErrorIs(t, err, tt.err)
+			var parseErr *ParseError
+			if assert.True(t, errors.As(err, &parseErr)) {
+				assert.Equal(t, tt.layer, parseErr.Layer)
+			}
+		})
+	}
+}
+
+func FuzzParseEthernet(f *testing.F) {
+	f.Add(ethernet(EtherTypeIPv4, nil, testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoTCP, payload: tcpHeader(1, 2)}.bytes()))
+	f.Add(ethernet(EtherTypeIPv4, []uint16{1, 2}, testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoUDP, fragment: 0x2000, payload: udpHeader(1, 2, nil)}.bytes()))
+	f.Add(ethernet(EtherTypeIPv6, []uint16{1}, testIPv6{src: "::1", dst: "::2", next: ProtoHopByHop, payload: concat(ipv6ExtHeader(ProtoFragment, 0), ipv6FragHeader(ProtoSCTP, 0, true), sctpHeader(1, 2))}.bytes()))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkParsed(t, data, func() (Headers, error) { return ParseEthernet(data) })
+	})
+}
+
+func FuzzParseIP(f *testing.F) {
+	f.Add(testIPv4{src: "10.0.0.1", dst: "10.0.0.2", proto: ProtoTCP, options: []byte{1, 1, 1, 1}, payload: tcpHeader(1, 2)}.bytes())
+	f.Add(testIPv6{src: "::1", dst: "::2", next: ProtoAH, payload: concat([]byte{ProtoUDP, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, udpHeader(1, 2, nil))}.bytes())
+	f.Add(testIPv6{src: "::1", dst: "::2", next: ProtoFragment, payload: concat(ipv6FragHeader(ProtoTCP, 8, false), []byte("data"))}.bytes())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkParsed(t, data, func() (Headers, error) { return ParseIP(data) })
+	})
+}
+
+// checkParsed checks the invariants of a parse result.
+func checkParsed(t *testing.T, data []byte, parse func() (Headers, error)) {
+	h, err := parse()
+	if err != nil {
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("error is not a ParseError: %v", err)
+		}
+		if !errors.Is(err, ErrTruncated) && !errors.Is(err, ErrMalformed) && !errors.Is(err, ErrUnsupported) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if parseErr.Offset < 0 || parseErr.Offset > len(data) {
+			t.Fatalf("error offset %d out of range [0, %d]", parseErr.Offset, len(data))
+		}
+		return
+	}
+	if h.IPVersion != 4 && h.IPVersion != 6 {
+		t.Fatalf("unexpected IP version %d", h.IPVersion)
+	}
+	if !h.SrcIP.IsValid() || !h.DstIP.IsValid() {
+		t.Fatalf("invalid addresses %v, %v", h.SrcIP, h.DstIP)
+	}
+	if len(h.Network)+len(h.Transport) > len(data) {
+		t.Fatalf("headers exceed the packet: %d + %d > %d", len(h.Network), len(h.Transport), len(data))
+	}
+}
+
+// Packet builders shared by the tuple_hash tests.
+
+func tuple(src string, srcPort uint16, dst string, dstPort uint16, proto uint8) FiveTuple {
+	return FiveTuple{
+		SrcIP:   netip.MustParseAddr(src),
+		DstIP:   netip.MustParseAddr(dst),
+		SrcPort: srcPort,
+		DstPort: dstPort,
+		Proto:   proto,
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	var b []byte
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func ethernet(etherType uint16, vlans []uint16, payload []byte) []byte {
+	b := make([]byte, 12, 14+len(vlans)*4+len(payload))
+	for i, vlan := range vlans {
+		tpid := EtherTypeVLAN
+		if i == 0 && len(vlans) > 1 {
+			tpid = EtherTypeQinQ
+		}
+		b = binary.BigEndian.AppendUint16(b, tpid)
+		b = binary.BigEndian.AppendUint16(b, vlan)
+	}
+	b = binary.BigEndian.AppendUint16(b, etherType)
+	return append(b, payload...)
+}
+
+type testIPv4 struct {
+	src, dst string
+	proto    uint8
+	options  []byte
+	// fragment is the flags and fragment offset field
+	fragment uint16
+	payload  []byte
+}
+
+func (p testIPv4) bytes() []byte {
+	headerLen := ipv4HeaderLen + len(p.options)
+	b := make([]byte, headerLen, headerLen+len(p.payload))
+	b[0] = 0x40 | byte(headerLen/4)
+	binary.BigEndian.PutUint16(b[2:], uint16(headerLen+len(p.payload)))
+	binary.BigEndian.PutUint16(b[6:], p.fragment)
+	b[8] = 64
+	b[9] = p.proto
+	src, dst := netip.MustParseAddr(p.src).As4(), netip.MustParseAddr(p.dst).As4()
+	copy(b[12:], src[:])
+	copy(b[16:], dst[:])
+	copy(b[20:], p.options)
+	return append(b, p.payload...)
+}
+
+type testIPv6 struct {
+	src, dst  string
+	flowLabel uint32
+	next      uint8
+	// payload includes extension headers
+	payload []byte
+}
+
+func (p testIPv6) bytes() []byte {
+	b := make([]byte, ipv6HeaderLen, ipv6HeaderLen+len(p.payload))
+	binary.BigEndian.PutUint32(b[0:], 6<<28|p.flowLabel)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(p.payload)))
+	b[6] = p.next
+	b[7] = 64
+	src, dst := netip.MustParseAddr(p.src).As16(), netip.MustParseAddr(p.dst).As16()
+	copy(b[8:], src[:])
+	copy(b[24:], dst[:])
+	return append(b, p.payload...)
+}
+
+// ipv6ExtHeader returns an options-like extension header of (length + 1) * 8 bytes.
+func ipv6ExtHeader(next uint8, length uint8) []byte {
+	b := make([]byte, (int(length)+1)*8)
+	b[0] = next
+	b[1] = length
+	return b
+}
+
+func ipv6FragHeader(next uint8, offset uint16, more bool) []byte {
+	b := make([]byte, 8)
+	b[0] = next
+	field := offset &^ 0x0007
+	if more {
+		field |= 1
+	}
+	binary.BigEndian.PutUint16(b[2:], field)
+	return b
+}
+
+func tcpHeader(src, dst uint16) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint16(b[0:], src)
+	binary.BigEndian.PutUint16(b[2:], dst)
+	b[12] = 5 << 4
+	return b
+}
+
+func udpHeader(src, dst uint16, payload []byte) []byte {
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(b[0:], src)
+	binary.BigEndian.PutUint16(b[2:], dst)
+	binary.BigEndian.PutUint16(b[4:], uint16(8+len(payload)))
+	return append(b, payload...)
+}
+
+func sctpHeader(src, dst uint16) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b[0:], src)
+	binary.BigEndian.PutUint16(b[2:], dst)
+	return b
+}
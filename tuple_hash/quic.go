@@ -0,0 +1,159 @@
+package tuple_hash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+)
+
+// DefaultQUICPort is the UDP port QUIC packets are expected on if QUIC.Ports is empty.
+const DefaultQUICPort uint16 = 443
+
+// MaxConnIDLen is the maximum length of a QUIC version 1 connection ID, see RFC 9000 Section 17.2.
+const MaxConnIDLen = 20
+
+var ErrInvalidConnIDLen = fmt.Errorf("invalid connection ID length")
+
+// QUIC versions with a known long header packet type encoding.
+const (
+	QUICVersion1 uint32 = 0x00000001
+	QUICVersion2 uint32 = 0x6b3343cf
+)
+
+// QUICPacketType is the type of long header QUIC packet.
+type QUICPacketType uint8
+
+const (
+	QUICInitial QUICPacketType = iota
+	QUIC0RTT
+	QUICHandshake
+	QUICRetry
+	// QUICUnknownType is the type of packets with an unknown version, including version negotiation.
+	QUICUnknownType
+)
+
+// QUICHeader is the invariant part of a QUIC packet header.
+type QUICHeader struct {
+	// Long is true for long header packets.
+	Long bool
+	// Version and Type are only set for long header packets.
+	Version uint32
+	Type    QUICPacketType
+	// DstConnID references the parsed buffer.
+	DstConnID []byte
+}
+
+// ParseQUIC parses the header of the QUIC packet carried in a UDP payload.
+// Short header packets do not carry the length of the destination connection ID,
+// so it must be given as shortConnIDLen, the length of connection IDs issued by the servers,
+// between 0 and MaxConnIDLen.
+// It does not allocate unless it returns an error.
+func ParseQUIC(payload []byte, shortConnIDLen int) (QUICHeader, error) {
+	if shortConnIDLen < 0 || shortConnIDLen > MaxConnIDLen {
+		return QUICHeader{}, fmt.Errorf("%w: %d", ErrInvalidConnIDLen, shortConnIDLen)
+	}
+	if len(payload) < 1 {
+		return QUICHeader{}, &ParseError{Layer: "quic", Offset: 0, Err: ErrTruncated}
+	}
+
+	// Short header
+	if payload[0]&0x80 == 0 {
+		if payload[0]&0x40 == 0 {
+			// The fixed bit is not set, this is not a QUIC packet
+			return QUICHeader{}, &ParseError{Layer: "quic", Offset: 0, Err: ErrUnsupported}
+		}
+		if len(payload) < 1+shortConnIDLen {
+			return QUICHeader{}, &ParseError{Layer: "quic", Offset: 0, Err: ErrTruncated}
+		}
+		return QUICHeader{DstConnID: payload[1 : 1+shortConnIDLen]}, nil
+	}
+
+	// Long header
+	if len(payload) < 6 {
+		return QUICHeader{}, &ParseError{Layer: "quic", Offset: 0, Err: ErrTruncated}
+	}
+	q := QUICHeader{
+		Long:    true,
+		Version: binary.BigEndian.Uint32(payload[1:]),
+		Type:    QUICUnknownType,
+	}
+	connIDLen := int(payload[5])
+	if len(payload) < 6+connIDLen {
+		return QUICHeader{}, &ParseError{Layer: "quic", Offset: 0, Err: ErrTruncated}
+	}
+	q.DstConnID = payload[6 : 6+connIDLen]
+
+	bits := QUICPacketType(payload[0]>>4) & 0x03
+	switch q.Version {
+	case QUICVersion1:
+		q.Type = bits
+	case QUICVersion2:
+		// RFC 9369: Initial 0b01, 0-RTT 0b10, Handshake 0b11, Retry 0b00
+		q.Type = (bits + 3) & 0x03
+	}
+	return q, nil
+}
+
+// QUIC hashes QUIC packets by their destination connection ID, so that flows keep their backend
+// when clients migrate to a new address. Other packets are hashed by the fallback policy.
+//
+// Initial and 0-RTT packets carry a connection ID chosen by the client, which is replaced by one chosen
+// by the server during the handshake. They are hashed by the fallback policy, like packets of unknown versions.
+// Backends must therefore be able to handle the rest of the connection arriving at the backend selected
+// by the connection ID, e.g. by issuing connection IDs that hash to themselves.
+type QUIC struct {
+	// ShortHeaderConnIDLen is the length of connection IDs issued by the servers, between 0 and MaxConnIDLen.
+	// Packets with an empty connection ID are hashed by the fallback policy.
+	ShortHeaderConnIDLen int `mapstructure:"short_header_conn_id_len"`
+	// Ports are the UDP destination ports QUIC packets are expected on. Defaults to DefaultQUICPort.
+	Ports []uint16 `mapstructure:"ports"`
+	// Fallback is the policy for non-QUIC packets, Initial and 0-RTT packets.
+	// Its key, if set, also keys the hash of connection IDs.
+	Fallback Policy `mapstructure:"fallback"`
+}
+
+// NewQUIC returns the QUIC policy of the configuration, or an error if the configuration is invalid.
+func NewQUIC(cfg QUIC) (*QUIC, error) {
+	if cfg.ShortHeaderConnIDLen < 0 || cfg.ShortHeaderConnIDLen > MaxConnIDLen {
+		return nil, fmt.Errorf("%w: %d, must be between 0 and %d", ErrInvalidConnIDLen, cfg.ShortHeaderConnIDLen, MaxConnIDLen)
+	}
+	return &cfg, nil
+}
+
+// IsQUIC returns true if the packet is a UDP packet to one of the QUIC ports.
+func (q *QUIC) IsQUIC(h *Headers) bool {
+	if h.Proto != ProtoUDP || h.IsFragment() || len(h.Transport) < 8 {
+		return false
+	}
+	return matchPort(h.DstPort, q.Ports, DefaultQUICPort)
+}
+
+// ConnID returns the destination connection ID to hash the packet by,
+// or false if the packet should be hashed by the fallback policy.
+func (q *QUIC) ConnID(h *Headers) ([]byte, bool) {
+	if !q.IsQUIC(h) {
+		return nil, false
+	}
+	header, err := ParseQUIC(h.Transport[8:], q.ShortHeaderConnIDLen)
+	if err != nil || len(header.DstConnID) == 0 {
+		return nil, false
+	}
+	// Of the long header packets, only Handshake packets carry a connection ID chosen by the server
+	if header.Long && header.Type != QUICHandshake {
+		return nil, false
+	}
+	return header.DstConnID, true
+}
+
+// Hash64 returns the 64-bit hash of the packet's destination connection ID,
+// or of the packet according to the fallback policy.
+// It does not allocate.
+func (q *QUIC) Hash64(h *Headers) uint64 {
+	if connID, ok := q.ConnID(h); ok {
+		if q.Fallback.Key != nil {
+			return q.Fallback.Key.Sum64(connID)
+		}
+		return crc64.Checksum(connID, crc64Table)
+	}
+	return q.Fallback.Hash64(h)
+}
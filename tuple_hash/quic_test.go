@@ -0,0 +1,178 @@
+package tuple_hash
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Packets of a single connection, following RFC 9001 Appendix A.
+var (
+	// Protected client Initial from RFC 9001 Appendix A.2, truncated after the first payload bytes
+	quicClientInitial = mustHex("c000000001088394c8f03e5157080000449e7b9aec34d1b1c98dd7689fb8ec11d242b123dc9bd8bab936b47d92ec356c0bab7df5976d27cd449f63300099f3991c260ec4c60d17b31f8429157bb35a1282a643a8d2262cad67500cadb8e7378c8eb7539ec4d4905fed1bee1fc8aafba17c750e2c7ace01e6005f80fcb7df621230c83711b39343fa028cea7f7fb5ff89eac2308249a02252155e2347b63d58c5457afd84d05dfffdb20392844ae812154682e9cf012f9021a6f0be17ddd0c2084dce25ff9b06cde535d0f920a2db1bf362c23e596d11a4f5a6cf3948838a3aec4e15daf8500a6ef69ec4e3feb6b1d98e610ac8b7ec3faf6ad760b7bad1db4ba3485e8a94dc250ae3fdb41ed15fb6a8e5eba0fc3dd60bc8e30c5c4287e53805db059ae0648db2f64264ed5e39be2e20d82df566da8dd5998ccabdae053060ae6c7b4378e846d29f37ed7b4ea9ec5d82e7961b7f25a9323851f681d582363aa5f89937f5a67258bf63ad6f1a0b1d96dbd4faddfcefc5266ba6611722395c906556be52afe3f565636ad1b17d508b73d8743eeb524be22b3dcbc2c7468d54119c7468449a13d8e3b95811a198f3491de3e7fe942b330407abf82a4ed7c1b311663ac69890f4157015853d91e923037c227a33cdd5ec281ca3f79c44546b9d90ca00f064c99e3dd97911d39fe9c5d0b23a229a234cb36186c4819e8b9c5927726632291d6a418211cc2962e20fe47feb3edf330f2c603a9d48c0fcb5699dbfe5896425c5bac4aee82e57a85aaf4e2513e4f05796b07ba2ee47d80506f8d2c25e50fd14de71e6c418559302f939b0e1abd576f279c4b2e0feb85c1f28ff18f58891ffef132eef2fa09346aee33c28eb130ff28f5b766953334113211996d20011a198e3fc433f9f2541010ae17c1bf202580f6047472fb36857fe843b19f5984009ddc324044e847a4f4a0ab34f719595de37252d6235365e9b84392b061085349d73203a4a13e96f5432ec0fd4a1ee65accdd5e3904df54c1da510b0ff20dcc0c77fcb2c0e0eb605cb0504db87632cf3d8b4dae6e705769d1de354270123cb11450efc60ac47683d7b8d0f811365565fd98c4c8eb936bcab8d069fc33bd801b03adea2e1fbc5aa463d08ca19896d2bf59a071b851e6c239052172f296bfb5e72404790a2181014f3b94a4e97d117b438130368cc39dbb2d198065ae3986547926cd2162f40a29f0c3c8745c0f50fba3852e566d44575c29d39a03f0cda721984b6f440591f355e12d439ff150aab7613499dbd49adabc8676eef023b15b65bfc5ca06948109f23f350db82123535eb8a7433bdabcb909271a6ecbcb58b936a88cd4e8f2e6ff5800175f113253d8fa9ca8885c2f552e657dc603f252e1a8e308f76f0be79e2fb8f5d5fbbe2e30ecadd220723c8c0aea8078cdfcb3868263ff8f0940054da48781893a7e49ad5aff4af300cd804a6b6279ab3ff3afb64491c85194aab760d58a606654f9f4400e8b38591356fbf6425aca26dc85244259ff2b19c41b9f96f3ca9ec1dde434da7d2d392b905ddf3d1f9af93d1af5950bd493f5aa731b4056df31bd267b6b90a079831aaf579be0a39013137aac6d404f518cfd46840647e78bfe706ca4cf5e9c5453e9f7cfd2b8b4c8d169a44e55c88d4a9a7f9474241e221af44860018ab0856972e194cd934")
+	// Client Handshake to the connection ID f067a5502a4262b5 chosen by the server in Appendix A.3
+	quicClientHandshake = mustHex("e00000000108f067a5502a4262b500401a6a1b8e4dbb2bd2e95b2c1d4b7d8e6a35b8f1c2")
+	// Client 1-RTT short header packet to the same connection ID
+	quicClientShort = mustHex("4ef067a5502a4262b5e3d1c2b1a09f8e7d6c5b4a39281706")
+	// Protected short header packet from RFC 9001 Appendix A.5, which uses an empty connection ID
+	quicEmptyConnIDShort = mustHex("4cfe4189655e5cd55c41f69080575d7999c25a5bfb")
+)
+
+func TestParseQUIC(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		connLen  int
+		expected QUICHeader
+		err      error
+	}{
+		{
+			name:     "v1 Initial",
+			payload:  quicClientInitial,
+			connLen:  8,
+			expected: QUICHeader{Long: true, Version: QUICVersion1, Type: QUICInitial, DstConnID: mustHex("8394c8f03e515708")},
+		},
+		{
+			name:     "v1 Handshake",
+			payload:  quicClientHandshake,
+			connLen:  8,
+			expected: QUICHeader{Long: true, Version: QUICVersion1, Type: QUICHandshake, DstConnID: mustHex("f067a5502a4262b5")},
+		},
+		{
+			name:     "v2 Initial",
+			payload:  mustHex("d36b3343cf088394c8f03e5157080000449e"),
+			connLen:  8,
+			expected: QUICHeader{Long: true, Version: QUICVersion2, Type: QUICInitial, DstConnID: mustHex("8394c8f03e515708")},
+		},
+		{
+			name:     "v2 Handshake",
+			payload:  mustHex("f06b3343cf08f067a5502a4262b500401a"),
+			connLen:  8,
+			expected: QUICHeader{Long: true, Version: QUICVersion2, Type: QUICHandshake, DstConnID: mustHex("f067a5502a4262b5")},
+		},
+		{
+			name:     "Version negotiation",
+			payload:  mustHex("80000000000008f067a5502a4262b500000001"),
+			connLen:  8,
+			expected: QUICHeader{Long: true, Version: 0, Type: QUICUnknownType, DstConnID: []byte{}},
+		},
+		{
+			name:     "Short header",
+			payload:  quicClientShort,
+			connLen:  8,
+			expected: QUICHeader{DstConnID: mustHex("f067a5502a4262b5")},
+		},
+		{
+			name:     "Short header with empty connection ID",
+			payload:  quicEmptyConnIDShort,
+			connLen:  0,
+			expected: QUICHeader{DstConnID: []byte{}},
+		},
+		{name: "Empty", payload: nil, err: ErrTruncated},
+		{name: "Fixed bit unset", payload: mustHex("0ef067a5502a4262b5"), connLen: 8, err: ErrUnsupported},
+		{name: "Truncated short header", payload: quicClientShort[:5], connLen: 8, err: ErrTruncated},
+		{name: "Truncated long header", payload: quicClientInitial[:5], connLen: 8, err: ErrTruncated},
+		{name: "Truncated connection ID", payload: quicClientInitial[:10], connLen: 8, err: ErrTruncated},
+		{name: "Negative connection ID length", payload: quicClientShort, connLen: -1, err: ErrInvalidConnIDLen},
+		{name: "Connection ID length too long", payload: quicClientShort, connLen: MaxConnIDLen + 1, err: ErrInvalidConnIDLen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := ParseQUIC(tt.payload, tt.connLen)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, q)
+		})
+	}
+}
+
+func TestQUIC(t *testing.T) {
+	quic := QUIC{ShortHeaderConnIDLen: 8}
+	packet := func(src string, srcPort uint16, payload []byte) Headers {
+		h, err := ParseIP(testIPv4{src: src, dst: "10.0.0.100", proto: ProtoUDP, payload: udpHeader(srcPort, 443, payload)}.bytes())
+		assert.NoError(t, err)
+		return h
+	}
+
+	t.Run("Connection ID survives migration", func(t *testing.T) {
+		before := packet("198.51.100.7", 51234, quicClientShort)
+		after := packet("203.0.113.9", 40000, quicClientShort)
+		assert.Equal(t, quic.Hash64(&before), quic.Hash64(&after))
+		assert.NotEqual(t, quic.Fallback.Hash64(&before), quic.Fallback.Hash64(&after))
+	})
+
+	t.Run("Handshake and short header packets hash the same", func(t *testing.T) {
+		handshake := packet("198.51.100.7", 51234, quicClientHandshake)
+		short := packet("203.0.113.9", 40000, quicClientShort)
+		assert.Equal(t, quic.Hash64(&handshake), quic.Hash64(&short))
+	})
+
+	t.Run("Fallback to 5-tuple", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			quic   QUIC
+			packet Headers
+		}{
+			{name: "Initial", quic: quic, packet: packet("198.51.100.7", 51234, quicClientInitial)},
+			{name: "0-RTT", quic: quic, packet: packet("198.51.100.7", 51234, mustHex("d000000001088394c8f03e5157080000449e"))},
+			{name: "Empty connection ID", quic: QUIC{}, packet: packet("198.51.100.7", 51234, quicEmptyConnIDShort)},
+			// DNS query header, the fixed bit is not set
+			{name: "Not QUIC", quic: quic, packet: packet("198.51.100.7", 51234, mustHex("1a2b01000001000000000000"))},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, ok := tt.quic.ConnID(&tt.packet)
+				assert.False(t, ok)
+				assert.Equal(t, tt.quic.Fallback.Hash64(&tt.packet), tt.quic.Hash64(&tt.packet))
+			})
+		}
+	})
+
+	t.Run("Ports", func(t *testing.T) {
+		h, err := ParseIP(testIPv4{src: "198.51.100.7", dst: "10.0.0.100", proto: ProtoUDP, payload: udpHeader(51234, 8443, quicClientShort)}.bytes())
+		assert.NoError(t, err)
+		assert.False(t, quic.IsQUIC(&h))
+
+		custom := QUIC{ShortHeaderConnIDLen: 8, Ports: []uint16{8443}}
+		assert.True(t, custom.IsQUIC(&h))
+		connID, ok := custom.ConnID(&h)
+		assert.True(t, ok)
+		assert.Equal(t, mustHex("f067a5502a4262b5"), connID)
+	})
+
+	t.Run("Invalid connection ID length", func(t *testing.T) {
+		for _, connLen := range []int{-1, MaxConnIDLen + 1} {
+			_, err := NewQUIC(QUIC{ShortHeaderConnIDLen: connLen})
+			assert.ErrorIs(t, err, ErrInvalidConnIDLen)
+
+			// Policies that are not created by NewQUIC fall back instead of panicking
+			invalid := QUIC{ShortHeaderConnIDLen: connLen}
+			h := packet("198.51.100.7", 51234, quicClientShort)
+			assert.Equal(t, invalid.Fallback.Hash64(&h), invalid.Hash64(&h))
+		}
+
+		valid, err := NewQUIC(QUIC{ShortHeaderConnIDLen: MaxConnIDLen})
+		assert.NoError(t, err)
+		assert.Equal(t, MaxConnIDLen, valid.ShortHeaderConnIDLen)
+	})
+
+	t.Run("Zero allocations", func(t *testing.T) {
+		h := packet("198.51.100.7", 51234, quicClientShort)
+		allocs := testing.AllocsPerRun(1000, func() {
+			_ = quic.Hash64(&h)
+		})
+		assert.Zero(t, allocs)
+	})
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,163 @@
+package tuple_hash
+
+import (
+	"fmt"
+	"github.com/creasty/defaults"
+	"net/netip"
+	"strings"
+)
+
+// KeySelector selects the key a flow is hashed by, which determines the flows that share a backend.
+type KeySelector interface {
+	// Key returns the 64-bit hash key of the flow, suitable as a chash.ConsistentHash key.
+	Key(t FiveTuple) uint64
+}
+
+// SelectorType is the type of built-in KeySelector.
+type SelectorType string
+
+const (
+	// Selector5Tuple keys by the full 5-tuple, spreading every connection independently.
+	Selector5Tuple SelectorType = "5-tuple"
+	// SelectorSrcIP keys by source IP, so that all connections of a client share a backend.
+	SelectorSrcIP SelectorType = "src-ip"
+	// SelectorSrcPrefix keys by source prefix, so that all clients behind a NAT pool share a backend.
+	SelectorSrcPrefix SelectorType = "src-prefix"
+	// SelectorDstPort keys by destination port only.
+	SelectorDstPort SelectorType = "dst-port"
+)
+
+var (
+	ErrUnknownSelector = fmt.Errorf("unknown key selector")
+	ErrInvalidPrefix   = fmt.Errorf("invalid prefix length")
+)
+
+// SelectorConfig configures a built-in KeySelector.
+type SelectorConfig struct {
+	// Type is the type of selector. Default is "5-tuple".
+	Type SelectorType `mapstructure:"type" default:"5-tuple"`
+	// PrefixLen is the source prefix length of IPv4 flows for "src-prefix". Default is 24.
+	// It is a pointer so that 0, which keys all IPv4 flows alike, can be set explicitly.
+	PrefixLen *int `mapstructure:"prefix_len" default:"24"`
+	// PrefixLenV6 is the source prefix length of IPv6 flows for "src-prefix". Default is 64.
+	PrefixLenV6 *int `mapstructure:"prefix_len_v6" default:"64"`
+}
+
+// VIPSelectorConfig configures the KeySelector of a VIP.
+type VIPSelectorConfig struct {
+	// VIP is the destination address of the flows, optionally with a port,
+	// e.g. "10.0.0.100" or "[2001:db8::100]:443".
+	VIP            string `mapstructure:"vip"`
+	SelectorConfig `mapstructure:",squash"`
+}
+
+// SelectorsConfig configures the KeySelector of each VIP.
+type SelectorsConfig struct {
+	// Default is the selector of flows to VIPs that are not configured.
+	Default SelectorConfig `mapstructure:"default"`
+	// VIPs are the selectors of specific VIPs. A VIP with a port takes precedence over one without.
+	VIPs []VIPSelectorConfig `mapstructure:"vips"`
+}
+
+// NewKeySelector creates a built-in KeySelector.
+// If key is not nil, keys are hashed with it, see KeyedHasher.
+func NewKeySelector(cfg SelectorConfig, key *KeyedHasher) (KeySelector, error) {
+	if err := defaults.Set(&cfg); err != nil {
+		return nil, err
+	}
+
+	hash := Hash64
+	if key != nil {
+		hash = key.Hash64
+	}
+
+	switch cfg.Type {
+	case Selector5Tuple:
+		return selectorFunc(func(t FiveTuple) uint64 {
+			return hash(t.SrcIP, t.SrcPort, t.DstIP, t.DstPort, t.Proto)
+		}), nil
+	case SelectorSrcIP:
+		return selectorFunc(func(t FiveTuple) uint64 {
+			return hash(t.SrcIP, 0, netip.Addr{}, 0, 0)
+		}), nil
+	case SelectorSrcPrefix:
+		prefixLen, prefixLenV6 := *cfg.PrefixLen, *cfg.PrefixLenV6
+		if prefixLen < 0 || prefixLen > 32 {
+			return nil, fmt.Errorf("%w: %d for IPv4", ErrInvalidPrefix, prefixLen)
+		}
+		if prefixLenV6 < 0 || prefixLenV6 > 128 {
+			return nil, fmt.Errorf("%w: %d for IPv6", ErrInvalidPrefix, prefixLenV6)
+		}
+		return selectorFunc(func(t FiveTuple) uint64 {
+			src := t.SrcIP.Unmap()
+			bits := prefixLenV6
+			if src.Is4() {
+				bits = prefixLen
+			}
+			// Cannot fail, the prefix lengths are validated above
+			prefix, _ := src.Prefix(bits)
+			return hash(prefix.Addr(), 0, netip.Addr{}, 0, 0)
+		}), nil
+	case SelectorDstPort:
+		return selectorFunc(func(t FiveTuple) uint64 {
+			return hash(netip.Addr{}, 0, netip.Addr{}, t.DstPort, 0)
+		}), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownSelector, cfg.Type)
+	}
+}
+
+type selectorFunc func(t FiveTuple) uint64
+
+func (f selectorFunc) Key(t FiveTuple) uint64 {
+	return f(t)
+}
+
+type vipSelectorImpl struct {
+	byAddrPort map[netip.AddrPort]KeySelector
+	byAddr     map[netip.Addr]KeySelector
+	fallback   KeySelector
+}
+
+// NewVIPKeySelector creates a KeySelector that selects the key of a flow
+// with the selector configured for its destination VIP.
+// If key is not nil, keys are hashed with it, see KeyedHasher.
+func NewVIPKeySelector(cfg SelectorsConfig, key *KeyedHasher) (KeySelector, error) {
+	fallback, err := NewKeySelector(cfg.Default, key)
+	if err != nil {
+		return nil, err
+	}
+	s := &vipSelectorImpl{
+		byAddrPort: make(map[netip.AddrPort]KeySelector),
+		byAddr:     make(map[netip.Addr]KeySelector),
+		fallback:   fallback,
+	}
+
+	for _, vipCfg := range cfg.VIPs {
+		selector, err := NewKeySelector(vipCfg.SelectorConfig, key)
+		if err != nil {
+			return nil, fmt.Errorf("vip %s: %w", vipCfg.VIP, err)
+		}
+		if addrPort, err := netip.ParseAddrPort(vipCfg.VIP); err == nil {
+			s.byAddrPort[netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())] = selector
+			continue
+		}
+		addr, err := netip.ParseAddr(strings.Trim(vipCfg.VIP, "[]"))
+		if err != nil {
+			return nil, fmt.Errorf("vip %s: %w", vipCfg.VIP, err)
+		}
+		s.byAddr[addr.Unmap()] = selector
+	}
+	return s, nil
+}
+
+func (s *vipSelectorImpl) Key(t FiveTuple) uint64 {
+	dst := t.DstIP.Unmap()
+	if selector, ok := s.byAddrPort[netip.AddrPortFrom(dst, t.DstPort)]; ok {
+		return selector.Key(t)
+	}
+	if selector, ok := s.byAddr[dst]; ok {
+		return selector.Key(t)
+	}
+	return s.fallback.Key(t)
+}
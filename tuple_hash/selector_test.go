@@ -0,0 +1,211 @@
+package tuple_hash
+
+import (
+	"bytes"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"maglev-go/x/ptr"
+	iviper "maglev-go/x/viper"
+	"testing"
+)
+
+func TestKeySelector(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       SelectorConfig
+		same      [][2]FiveTuple
+		different [][2]FiveTuple
+	}{
+		{
+			name: "5-tuple",
+			cfg:  SelectorConfig{Type: Selector5Tuple},
+			same: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP)},
+			},
+			different: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.100.7", 51235, "10.0.0.100", 443, ProtoTCP)},
+			},
+		},
+		{
+			name: "Default is 5-tuple",
+			cfg:  SelectorConfig{},
+			different: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.100.7", 51235, "10.0.0.100", 443, ProtoTCP)},
+			},
+		},
+		{
+			name: "src-ip",
+			cfg:  SelectorConfig{Type: SelectorSrcIP},
+			same: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.100.7", 40000, "10.0.0.100", 80, ProtoUDP)},
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("::ffff:198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP)},
+			},
+			different: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.100.8", 51234, "10.0.0.100", 443, ProtoTCP)},
+			},
+		},
+		{
+			name: "src-prefix defaults to /24 and /64",
+			cfg:  SelectorConfig{Type: SelectorSrcPrefix},
+			same: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.100.200", 40000, "10.0.0.100", 443, ProtoTCP)},
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("::ffff:198.51.100.8", 40000, "10.0.0.100", 443, ProtoTCP)},
+				{tuple("2001:db8:1:2::7", 51234, "2001:db8::100", 443, ProtoTCP), tuple("2001:db8:1:2:ffff::1", 40000, "2001:db8::100", 443, ProtoTCP)},
+			},
+			different: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.101.7", 51234, "10.0.0.100", 443, ProtoTCP)},
+				{tuple("2001:db8:1:2::7", 51234, "2001:db8::100", 443, ProtoTCP), tuple("2001:db8:1:3::7", 51234, "2001:db8::100", 443, ProtoTCP)},
+			},
+		},
+		{
+			name: "src-prefix with custom lengths",
+			cfg:  SelectorConfig{Type: SelectorSrcPrefix, PrefixLen: ptr.ToPtr(16), PrefixLenV6: ptr.ToPtr(48)},
+			same: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.0.1", 40000, "10.0.0.100", 443, ProtoTCP)},
+				{tuple("2001:db8:1:2::7", 51234, "2001:db8::100", 443, ProtoTCP), tuple("2001:db8:1:3::7", 51234, "2001:db8::100", 443, ProtoTCP)},
+			},
+			different: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.52.100.7", 51234, "10.0.0.100", 443, ProtoTCP)},
+			},
+		},
+		{
+			name: "src-prefix with zero lengths",
+			cfg:  SelectorConfig{Type: SelectorSrcPrefix, PrefixLen: ptr.ToPtr(0), PrefixLenV6: ptr.ToPtr(0)},
+			same: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("203.0.113.9", 40000, "10.0.0.100", 443, ProtoTCP)},
+				{tuple("2001:db8:1:2::7", 51234, "2001:db8::100", 443, ProtoTCP), tuple("2001:db9::7", 51234, "2001:db8::100", 443, ProtoTCP)},
+			},
+		},
+		{
+			name: "dst-port",
+			cfg:  SelectorConfig{Type: SelectorDstPort},
+			same: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("203.0.113.9", 40000, "10.0.0.101", 443, ProtoUDP)},
+			},
+			different: [][2]FiveTuple{
+				{tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), tuple("198.51.100.7", 51234, "10.0.0.100", 80, ProtoTCP)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := NewKeySelector(tt.cfg, nil)
+			assert.NoError(t, err)
+			for _, pair := range tt.same {
+				assert.Equal(t, selector.Key(pair[0]), selector.Key(pair[1]), "%s and %s", pair[0], pair[1])
+			}
+			for _, pair := range tt.different {
+				assert.NotEqual(t, selector.Key(pair[0]), selector.Key(pair[1]), "%s and %s", pair[0], pair[1])
+			}
+		})
+	}
+
+	t.Run("Keyed", func(t *testing.T) {
+		key, err := NewKeyedHasher([]byte("0123456789abcdef"))
+		assert.NoError(t, err)
+		keyed, err := NewKeySelector(SelectorConfig{Type: Selector5Tuple}, key)
+		assert.NoError(t, err)
+		unkeyed, err := NewKeySelector(SelectorConfig{Type: Selector5Tuple}, nil)
+		assert.NoError(t, err)
+
+		f := tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP)
+		assert.Equal(t, f.Hash64(), unkeyed.Key(f))
+		assert.Equal(t, key.Hash64(f.SrcIP, f.SrcPort, f.DstIP, f.DstPort, f.Proto), keyed.Key(f))
+	})
+
+	t.Run("Zero prefix length from config", func(t *testing.T) {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		assert.NoError(t, v.ReadConfig(bytes.NewBufferString("type: src-prefix\nprefix_len: 0\n")))
+		var cfg SelectorConfig
+		assert.NoError(t, iviper.Unmarshal(v, &cfg))
+
+		selector, err := NewKeySelector(cfg, nil)
+		assert.NoError(t, err)
+		assert.Equal(t,
+			selector.Key(tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP)),
+			selector.Key(tuple("203.0.113.9", 40000, "10.0.0.100", 443, ProtoTCP)))
+		// The IPv6 prefix length keeps its default
+		assert.NotEqual(t,
+			selector.Key(tuple("2001:db8:1:2::7", 51234, "2001:db8::100", 443, ProtoTCP)),
+			selector.Key(tuple("2001:db8:1:3::7", 51234, "2001:db8::100", 443, ProtoTCP)))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := NewKeySelector(SelectorConfig{Type: "dst-ip"}, nil)
+		assert.ErrorIs(t, err, ErrUnknownSelector)
+		_, err = NewKeySelector(SelectorConfig{Type: SelectorSrcPrefix, PrefixLen: ptr.ToPtr(33)}, nil)
+		assert.ErrorIs(t, err, ErrInvalidPrefix)
+		_, err = NewKeySelector(SelectorConfig{Type: SelectorSrcPrefix, PrefixLenV6: ptr.ToPtr(129)}, nil)
+		assert.ErrorIs(t, err, ErrInvalidPrefix)
+		_, err = NewVIPKeySelector(SelectorsConfig{VIPs: []VIPSelectorConfig{{VIP: "not-an-ip"}}}, nil)
+		assert.Error(t, err)
+		_, err = NewVIPKeySelector(SelectorsConfig{VIPs: []VIPSelectorConfig{{VIP: "10.0.0.100", SelectorConfig: SelectorConfig{Type: "foo"}}}}, nil)
+		assert.ErrorIs(t, err, ErrUnknownSelector)
+	})
+}
+
+func TestVIPKeySelector(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.NoError(t, v.ReadConfig(bytes.NewBufferString(`
+default:
+  type: 5-tuple
+vips:
+  - vip: 10.0.0.100
+    type: src-ip
+  - vip: 10.0.0.100:8443
+    type: src-prefix
+    prefix_len: 24
+  - vip: "[2001:db8::100]:443"
+    type: src-prefix
+    prefix_len_v6: 56
+  - vip: 10.0.0.200
+    type: dst-port
+`)))
+
+	var cfg SelectorsConfig
+	assert.NoError(t, iviper.Unmarshal(v, &cfg))
+	assert.Equal(t, SelectorSrcPrefix, cfg.VIPs[1].Type)
+	assert.Equal(t, ptr.ToPtr(24), cfg.VIPs[1].PrefixLen)
+
+	selector, err := NewVIPKeySelector(cfg, nil)
+	assert.NoError(t, err)
+
+	srcIP, err := NewKeySelector(SelectorConfig{Type: SelectorSrcIP}, nil)
+	assert.NoError(t, err)
+	srcPrefix, err := NewKeySelector(SelectorConfig{Type: SelectorSrcPrefix, PrefixLen: ptr.ToPtr(24), PrefixLenV6: ptr.ToPtr(56)}, nil)
+	assert.NoError(t, err)
+	dstPort, err := NewKeySelector(SelectorConfig{Type: SelectorDstPort}, nil)
+	assert.NoError(t, err)
+	fiveTuple, err := NewKeySelector(SelectorConfig{}, nil)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		flow     FiveTuple
+		expected KeySelector
+	}{
+		{name: "VIP without port", flow: tuple("198.51.100.7", 51234, "10.0.0.100", 443, ProtoTCP), expected: srcIP},
+		{name: "VIP with port takes precedence", flow: tuple("198.51.100.7", 51234, "10.0.0.100", 8443, ProtoTCP), expected: srcPrefix},
+		{name: "IPv6 VIP with port", flow: tuple("2001:db8:1:2::7", 51234, "2001:db8::100", 443, ProtoTCP), expected: srcPrefix},
+		{name: "IPv6 VIP on another port", flow: tuple("2001:db8:1:2::7", 51234, "2001:db8::100", 80, ProtoTCP), expected: fiveTuple},
+		{name: "IPv4-mapped VIP", flow: tuple("198.51.100.7", 51234, "::ffff:10.0.0.200", 53, ProtoUDP), expected: dstPort},
+		{name: "Unknown VIP", flow: tuple("198.51.100.7", 51234, "10.0.0.1", 443, ProtoTCP), expected: fiveTuple},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected.Key(tt.flow), selector.Key(tt.flow))
+		})
+	}
+
+	t.Run("Zero allocations", func(t *testing.T) {
+		flow := tuple("2001:db8:1:2::7", 51234, "2001:db8::100", 443, ProtoTCP)
+		allocs := testing.AllocsPerRun(1000, func() {
+			_ = selector.Key(flow)
+		})
+		assert.Zero(t, allocs)
+	})
+}
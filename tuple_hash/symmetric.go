@@ -0,0 +1,38 @@
+package tuple_hash
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+)
+
+// HashSymmetric is like Hash, but returns the same hash for both directions of a flow,
+// i.e. swapping source and destination does not change the result.
+// Use it when both directions of a connection must land on the same backend,
+// for example with stateful middleboxes.
+func HashSymmetric(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, proto uint8) (uint32, error) {
+	if c := bytes.Compare(srcIP.To16(), dstIP.To16()); c > 0 || (c == 0 && srcPort > dstPort) {
+		srcIP, srcPort, dstIP, dstPort = dstIP, dstPort, srcIP, srcPort
+	}
+	return Hash(srcIP, srcPort, dstIP, dstPort, proto)
+}
+
+// Hash64Symmetric is like Hash64, but returns the same hash for both directions of a flow.
+// It does not allocate.
+func Hash64Symmetric(srcIP netip.Addr, srcPort uint16, dstIP netip.Addr, dstPort uint16, proto uint8) uint64 {
+	if !endpointsOrdered(srcIP, srcPort, dstIP, dstPort) {
+		srcIP, srcPort, dstIP, dstPort = dstIP, dstPort, srcIP, srcPort
+	}
+	return Hash64(srcIP, srcPort, dstIP, dstPort, proto)
+}
+
+// endpointsOrdered returns true if the source endpoint sorts before or equal to the destination endpoint.
+// Addresses are compared in their 16-byte form, so that IPv4 and IPv4-mapped IPv6 addresses
+// order the same way they are hashed.
+func endpointsOrdered(srcIP netip.Addr, srcPort uint16, dstIP netip.Addr, dstPort uint16) bool {
+	src, dst := srcIP.As16(), dstIP.As16()
+	if c := bytes.Compare(src[:], dst[:]); c != 0 {
+		return c < 0
+	}
+	return srcPort <= dstPort
+}
@@ -0,0 +1,61 @@
+package tuple_hash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestHashSymmetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		srcIP   string
+		srcPort uint16
+		dstIP   string
+		dstPort uint16
+		proto   uint8
+	}{
+		{name: "IPv4 TCP", srcIP: "10.0.0.1", srcPort: 51234, dstIP: "192.168.1.10", dstPort: 443, proto: 6},
+		{name: "IPv4 UDP, higher source address", srcIP: "203.0.113.7", srcPort: 53, dstIP: "10.1.2.3", dstPort: 40000, proto: 17},
+		{name: "IPv4 same address, different ports", srcIP: "127.0.0.1", srcPort: 8080, dstIP: "127.0.0.1", dstPort: 9090, proto: 6},
+		{name: "IPv6 TCP", srcIP: "2001:db8::1", srcPort: 51234, dstIP: "2001:db8:ffff::2", dstPort: 443, proto: 6},
+		{name: "IPv6 UDP, higher source address", srcIP: "fe80::1:2", srcPort: 4789, dstIP: "2001:db8::a", dstPort: 4789, proto: 17},
+		{name: "IPv6 same address, different ports", srcIP: "::1", srcPort: 9090, dstIP: "::1", dstPort: 8080, proto: 132},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := net.ParseIP(tt.srcIP), net.ParseIP(tt.dstIP)
+			forward, err := HashSymmetric(src, tt.srcPort, dst, tt.dstPort, tt.proto)
+			assert.NoError(t, err)
+			reverse, err := HashSymmetric(dst, tt.dstPort, src, tt.srcPort, tt.proto)
+			assert.NoError(t, err)
+			assert.Equal(t, forward, reverse)
+
+			// The symmetric hash equals the directional hash of one of the directions
+			directional, err := Hash(src, tt.srcPort, dst, tt.dstPort, tt.proto)
+			assert.NoError(t, err)
+			reversedDirectional, err := Hash(dst, tt.dstPort, src, tt.srcPort, tt.proto)
+			assert.NoError(t, err)
+			assert.Contains(t, []uint32{directional, reversedDirectional}, forward)
+
+			src64, dst64 := netip.MustParseAddr(tt.srcIP), netip.MustParseAddr(tt.dstIP)
+			assert.Equal(t,
+				Hash64Symmetric(src64, tt.srcPort, dst64, tt.dstPort, tt.proto),
+				Hash64Symmetric(dst64, tt.dstPort, src64, tt.srcPort, tt.proto),
+			)
+		})
+	}
+
+	t.Run("Different flows hash differently", func(t *testing.T) {
+		a, b := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+		assert.NotEqual(t, Hash64Symmetric(a, 1000, b, 80, 6), Hash64Symmetric(a, 1001, b, 80, 6))
+		assert.NotEqual(t, Hash64Symmetric(a, 1000, b, 80, 6), Hash64Symmetric(a, 1000, b, 80, 17))
+	})
+
+	t.Run("IPv4-mapped IPv6 orders like IPv4", func(t *testing.T) {
+		a, b := netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("::ffff:10.0.0.1")
+		assert.Equal(t, Hash64Symmetric(a, 80, b, 1000, 6), Hash64Symmetric(b.Unmap(), 1000, a, 80, 6))
+	})
+}
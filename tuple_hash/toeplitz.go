@@ -0,0 +1,95 @@
+package tuple_hash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+var (
+	ErrInvalidToeplitzKey = fmt.Errorf("toeplitz key must be 40 or 52 bytes")
+)
+
+// MicrosoftRSSKey is the default 40-byte RSS key from the Microsoft RSS specification,
+// also the default of many NIC drivers.
+var MicrosoftRSSKey = []byte{
+	0x6d, 0x5a, 0x56, 0xda, 0x25, 0x5b, 0x0e, 0xc2,
+	0x41, 0x67, 0x25, 0x3d, 0x43, 0xa3, 0x8f, 0xb0,
+	0xd0, 0xca, 0x2b, 0xcb, 0xae, 0x7b, 0x30, 0xb4,
+	0x77, 0xcb, 0x2d, 0xa3, 0x80, 0x30, 0xf2, 0x0c,
+	0x6a, 0x42, 0xb7, 0x3b, 0xbe, 0xac, 0x01, 0xfa,
+}
+
+// Toeplitz is the Toeplitz hash used by NICs for receive-side scaling (RSS).
+// Hashing with the same key as the NIC selects the same RSS queue for a flow,
+// so that software steering can be aligned with hardware steering.
+//
+// Inputs follow the Microsoft RSS specification: source address, destination address,
+// then source port and destination port for the 4-tuple, all in network byte order.
+type Toeplitz struct {
+	key []byte
+}
+
+// NewToeplitz creates a new Toeplitz hash with the given key.
+// The key must be 40 bytes (Microsoft) or 52 bytes (e.g. Intel i40e/ice).
+func NewToeplitz(key []byte) (*Toeplitz, error) {
+	if len(key) != 40 && len(key) != 52 {
+		return nil, ErrInvalidToeplitzKey
+	}
+	return &Toeplitz{key: append([]byte(nil), key...)}, nil
+}
+
+// Hash returns the Toeplitz hash of the input.
+// Inputs longer than the key allows (len(key) - 4 bytes) are hashed against zero key bits.
+func (t *Toeplitz) Hash(input []byte) uint32 {
+	var result uint32
+	// window holds the 32 key bits aligned with the current input bit
+	window := binary.BigEndian.Uint32(t.key)
+	for i, b := range input {
+		var next byte
+		if i+4 < len(t.key) {
+			next = t.key[i+4]
+		}
+		for bit := 7; bit >= 0; bit-- {
+			if b&(1<<bit) != 0 {
+				result ^= window
+			}
+			window = window<<1 | uint32(next>>bit)&1
+		}
+	}
+	return result
+}
+
+// Hash2Tuple returns the Toeplitz hash of the source and destination addresses.
+// If both addresses are IPv4 (or IPv4-mapped IPv6), the IPv4 input is used, otherwise the IPv6 input.
+func (t *Toeplitz) Hash2Tuple(srcIP netip.Addr, dstIP netip.Addr) uint32 {
+	var buf [32]byte
+	n := putAddrs(buf[:], srcIP, dstIP)
+	return t.Hash(buf[:n])
+}
+
+// Hash4Tuple returns the Toeplitz hash of the source and destination addresses and ports.
+// If both addresses are IPv4 (or IPv4-mapped IPv6), the IPv4 input is used, otherwise the IPv6 input.
+func (t *Toeplitz) Hash4Tuple(srcIP netip.Addr, srcPort uint16, dstIP netip.Addr, dstPort uint16) uint32 {
+	var buf [36]byte
+	n := putAddrs(buf[:], srcIP, dstIP)
+	binary.BigEndian.PutUint16(buf[n:], srcPort)
+	binary.BigEndian.PutUint16(buf[n+2:], dstPort)
+	return t.Hash(buf[:n+4])
+}
+
+// putAddrs writes the addresses in their RSS input form into buf,
+// and returns the number of bytes written.
+func putAddrs(buf []byte, srcIP netip.Addr, dstIP netip.Addr) int {
+	srcIP, dstIP = srcIP.Unmap(), dstIP.Unmap()
+	if srcIP.Is4() && dstIP.Is4() {
+		src, dst := srcIP.As4(), dstIP.As4()
+		copy(buf[0:4], src[:])
+		copy(buf[4:8], dst[:])
+		return 8
+	}
+	src, dst := srcIP.As16(), dstIP.As16()
+	copy(buf[0:16], src[:])
+	copy(buf[16:32], dst[:])
+	return 32
+}
@@ -0,0 +1,65 @@
+package tuple_hash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/netip"
+	"testing"
+)
+
+// TestToeplitz validates the Toeplitz hash against the verification suite
+// of the Microsoft RSS specification.
+func TestToeplitz(t *testing.T) {
+	tests := []struct {
+		name      string
+		dst       string
+		src       string
+		expected  uint32 // 2-tuple
+		expected4 uint32 // 4-tuple
+	}{
+		{name: "IPv4 #1", dst: "161.142.100.80:1766", src: "66.9.149.187:2794", expected: 0x323e8fc2, expected4: 0x51ccc178},
+		{name: "IPv4 #2", dst: "65.69.140.83:4739", src: "199.92.111.2:14230", expected: 0xd718262a, expected4: 0xc626b0ea},
+		{name: "IPv4 #3", dst: "12.22.207.184:38024", src: "24.19.198.95:12898", expected: 0xd2d0a5de, expected4: 0x5c2b394a},
+		{name: "IPv4 #4", dst: "209.142.163.6:2217", src: "38.27.205.30:48228", expected: 0x82989176, expected4: 0xafc7327f},
+		{name: "IPv4 #5", dst: "202.188.127.2:1303", src: "153.39.163.191:44251", expected: 0x5d1809c5, expected4: 0x10e828a2},
+		{name: "IPv6 #1", dst: "[3ffe:2501:200:3::1]:1766", src: "[3ffe:2501:200:1fff::7]:2794", expected: 0x2cc18cd5, expected4: 0x40207d3d},
+		{name: "IPv6 #2", dst: "[ff02::1]:4739", src: "[3ffe:501:8::260:97ff:fe40:efab]:14230", expected: 0x0f0c461c, expected4: 0xdde51bbf},
+		{name: "IPv6 #3", dst: "[fe80::200:f8ff:fe21:67cf]:38024", src: "[3ffe:1900:4545:3:200:f8ff:fe21:67cf]:44251", expected: 0x4b61e985, expected4: 0x02d1feef},
+	}
+
+	toeplitz, err := NewToeplitz(MicrosoftRSSKey)
+	assert.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := netip.MustParseAddrPort(tt.src), netip.MustParseAddrPort(tt.dst)
+			assert.Equal(t, tt.expected, toeplitz.Hash2Tuple(src.Addr(), dst.Addr()))
+			assert.Equal(t, tt.expected4, toeplitz.Hash4Tuple(src.Addr(), src.Port(), dst.Addr(), dst.Port()))
+		})
+	}
+
+	t.Run("52-byte key", func(t *testing.T) {
+		key := append(append([]byte(nil), MicrosoftRSSKey...), make([]byte, 12)...)
+		long, err := NewToeplitz(key)
+		assert.NoError(t, err)
+
+		// Trailing key bytes beyond the input length do not change the hash
+		src, dst := netip.MustParseAddr("66.9.149.187"), netip.MustParseAddr("161.142.100.80")
+		assert.Equal(t, toeplitz.Hash4Tuple(src, 2794, dst, 1766), long.Hash4Tuple(src, 2794, dst, 1766))
+	})
+
+	t.Run("Invalid key", func(t *testing.T) {
+		for _, size := range []int{0, 16, 39, 41, 64} {
+			_, err := NewToeplitz(make([]byte, size))
+			assert.ErrorIs(t, err, ErrInvalidToeplitzKey, "size %d", size)
+		}
+	})
+
+	t.Run("Key is copied", func(t *testing.T) {
+		key := append([]byte(nil), MicrosoftRSSKey...)
+		toeplitz, err := NewToeplitz(key)
+		assert.NoError(t, err)
+		key[0] = 0
+		src, dst := netip.MustParseAddr("66.9.149.187"), netip.MustParseAddr("161.142.100.80")
+		assert.Equal(t, uint32(0x323e8fc2), toeplitz.Hash2Tuple(src, dst))
+	})
+}
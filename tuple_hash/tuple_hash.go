@@ -6,9 +6,17 @@ package tuple_hash
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"hash/crc64"
 	"net"
+	"net/netip"
 )
 
+// tupleLen is the length of the serialized 5-tuple:
+// two 16-byte addresses, two 2-byte ports and 1-byte protocol.
+const tupleLen = 16 + 16 + 2 + 2 + 1
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
 func Hash(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, proto uint8) (uint32, error) {
 	hash := crc32.NewIEEE()
 
@@ -37,3 +45,22 @@ func Hash(srcIP net.IP, srcPort uint16, dstIP net.IP, dstPort uint16, proto uint
 
 	return hash.Sum32(), nil
 }
+
+// Hash64 returns the 64-bit hash of the 5-tuple, suitable as a chash.ConsistentHash key.
+// IPv4 addresses are hashed in their IPv4-mapped IPv6 form, same as Hash.
+// It does not allocate, so it can be used per packet.
+func Hash64(srcIP netip.Addr, srcPort uint16, dstIP netip.Addr, dstPort uint16, proto uint8) uint64 {
+	var buf [tupleLen]byte
+	putTuple(&buf, srcIP, srcPort, dstIP, dstPort, proto)
+	return crc64.Checksum(buf[:], crc64Table)
+}
+
+// putTuple serializes the 5-tuple into buf in the same layout Hash writes it.
+func putTuple(buf *[tupleLen]byte, srcIP netip.Addr, srcPort uint16, dstIP netip.Addr, dstPort uint16, proto uint8) {
+	src, dst := srcIP.As16(), dstIP.As16()
+	copy(buf[0:16], src[:])
+	copy(buf[16:32], dst[:])
+	binary.BigEndian.PutUint16(buf[32:34], srcPort)
+	binary.BigEndian.PutUint16(buf[34:36], dstPort)
+	buf[36] = proto
+}
@@ -0,0 +1,64 @@
+package tuple_hash
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestHash64(t *testing.T) {
+	src, dst := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+
+	t.Run("Deterministic", func(t *testing.T) {
+		assert.Equal(t, Hash64(src, 1234, dst, 80, 6), Hash64(src, 1234, dst, 80, 6))
+	})
+
+	t.Run("Every field contributes", func(t *testing.T) {
+		base := Hash64(src, 1234, dst, 80, 6)
+		assert.NotEqual(t, base, Hash64(netip.MustParseAddr("10.0.0.3"), 1234, dst, 80, 6))
+		assert.NotEqual(t, base, Hash64(src, 1235, dst, 80, 6))
+		assert.NotEqual(t, base, Hash64(src, 1234, netip.MustParseAddr("10.0.0.3"), 80, 6))
+		assert.NotEqual(t, base, Hash64(src, 1234, dst, 81, 6))
+		assert.NotEqual(t, base, Hash64(src, 1234, dst, 80, 17))
+		assert.NotEqual(t, base, Hash64(dst, 80, src, 1234, 6))
+	})
+
+	t.Run("IPv4 and IPv4-mapped IPv6 hash the same", func(t *testing.T) {
+		mapped := netip.AddrFrom16(src.As16())
+		assert.Equal(t, Hash64(src, 1234, dst, 80, 6), Hash64(mapped, 1234, dst, 80, 6))
+	})
+
+	t.Run("Zero allocations", func(t *testing.T) {
+		v6 := netip.MustParseAddr("2001:db8::1")
+		allocs := testing.AllocsPerRun(1000, func() {
+			_ = Hash64(v6, 1234, dst, 80, 6)
+		})
+		assert.Zero(t, allocs)
+	})
+}
+
+func BenchmarkHash(b *testing.B) {
+	src, dst := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = Hash(src, uint16(i), dst, 80, 6)
+	}
+}
+
+func BenchmarkHash64(b *testing.B) {
+	b.Run("IPv4", func(b *testing.B) {
+		src, dst := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = Hash64(src, uint16(i), dst, 80, 6)
+		}
+	})
+	b.Run("IPv6", func(b *testing.B) {
+		src, dst := netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = Hash64(src, uint16(i), dst, 80, 6)
+		}
+	})
+}